@@ -2,99 +2,148 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
 	"time"
 
+	"github.com/maciekb2/task-manager/logging"
 	pb "github.com/maciekb2/task-manager/proto"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/sdk/resource"
-	tracesdk "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
-// tracerProvider returns an OpenTelemetry TracerProvider configured to use
-// the Jaeger exporter.
-func tracerProvider(url string) (*tracesdk.TracerProvider, error) {
-	// Create the Jaeger exporter
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(url)))
-	if err != nil {
-		return nil, err
-	}
-	tp := tracesdk.NewTracerProvider(
-		// Always be sure to batch in production.
-		tracesdk.WithBatcher(exp),
-		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceName("taskmanager-client"),
-		)),
-	)
-	return tp, nil
+// defaultTimeout is how long commands may take when -timeout is not given.
+// Commands following a task until it finishes are not limited.
+const defaultTimeout = 30 * time.Second
+
+// options are the flags shared by every command. They can be given before or
+// after the command name.
+type options struct {
+	server        string
+	timeout       time.Duration
+	output        string
+	useTLS        bool
+	tlsCAFile     string
+	tlsCertFile   string
+	tlsKeyFile    string
+	tlsServerName string
+	apiKey        string
+	bearerToken   string
+	logFormat     string
+	logLevel      string
 }
 
-// main is the entry point for the client application.
-// It connects to the gRPC server, submits a task, and streams its status.
-func main() {
-	// Initialize OpenTelemetry
-	tp, err := tracerProvider("http://jaeger:14268/api/traces")
-	if err != nil {
-		log.Fatal(err)
+// defaultOptions returns the options used when no flag is given, taking the
+// server address, credentials and logging from the environment.
+func defaultOptions() options {
+	return options{
+		server:      envOr("TASKMANAGER_SERVER", "localhost:50051"),
+		output:      outputTable,
+		apiKey:      os.Getenv("TASKMANAGER_API_KEY"),
+		bearerToken: os.Getenv("TASKMANAGER_TOKEN"),
+		logFormat:   envOr("TASKMANAGER_LOG_FORMAT", logging.FormatText),
+		logLevel:    envOr("TASKMANAGER_LOG_LEVEL", "warn"),
 	}
-	otel.SetTracerProvider(tp)
+}
 
-	// Connect to the server.
-	conn, err := grpc.Dial("taskmanager-service:50051",
-		grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
-		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
-	)
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
-	}
-	defer conn.Close()
+// register adds the shared flags to fs, with the current options as
+// defaults.
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.server, "server", o.server, "address of the task manager server (env TASKMANAGER_SERVER)")
+	fs.DurationVar(&o.timeout, "timeout", o.timeout, "how long the command may take, 0 for no limit (default 30s, no limit for commands following a task)")
+	fs.StringVar(&o.output, "o", o.output, `output format, "table", "json" or "yaml"`)
+	fs.BoolVar(&o.useTLS, "tls", o.useTLS, "connect to the server over TLS")
+	fs.StringVar(&o.tlsCAFile, "tls-ca-file", o.tlsCAFile, "CA bundle for verifying the server certificate, the system roots when empty")
+	fs.StringVar(&o.tlsCertFile, "tls-cert-file", o.tlsCertFile, "client certificate file for mutual TLS")
+	fs.StringVar(&o.tlsKeyFile, "tls-key-file", o.tlsKeyFile, "client private key file for mutual TLS")
+	fs.StringVar(&o.tlsServerName, "tls-server-name", o.tlsServerName, "server name to verify, the host of the server address when empty")
+	fs.StringVar(&o.apiKey, "api-key", o.apiKey, "API key sent to the server (env TASKMANAGER_API_KEY)")
+	fs.StringVar(&o.bearerToken, "token", o.bearerToken, "JWT bearer token sent to the server (env TASKMANAGER_TOKEN)")
+	fs.StringVar(&o.logFormat, "log-format", o.logFormat, `log format, "text" or "json" (env TASKMANAGER_LOG_FORMAT)`)
+	fs.StringVar(&o.logLevel, "log-level", o.logLevel, `lowest level logged: "debug", "info", "warn" or "error" (env TASKMANAGER_LOG_LEVEL)`)
+}
 
-	client := pb.NewTaskManagerClient(conn)
+// envOr returns the environment variable, or def when it is not set.
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
 
-	// Submit a new task with a priority.
-	taskCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+// transportCredentials returns the credentials used to connect to the server:
+// plaintext unless TLS is enabled, with a client certificate for mutual TLS
+// when one is given.
+func (o *options) transportCredentials() (credentials.TransportCredentials, error) {
+	if !o.useTLS {
+		return insecure.NewCredentials(), nil
+	}
 
-	taskDescription := "Sample Task"
-	priority := "HIGH" // Priority can be changed dynamically.
+	config := &tls.Config{
+		ServerName: o.tlsServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if o.tlsCAFile != "" {
+		pem, err := os.ReadFile(o.tlsCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", o.tlsCAFile)
+		}
+		config.RootCAs = pool
+	}
+	if o.tlsCertFile != "" || o.tlsKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.tlsCertFile, o.tlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
 
-	log.Printf("Submitting a new task: %s with priority: %s", taskDescription, priority)
+// tokenCredentials sends the API key or bearer token with every RPC. They are
+// also sent over plaintext connections, so the server can be run without TLS
+// inside a trusted network.
+type tokenCredentials struct {
+	apiKey, token string
+}
 
-	res, err := client.SubmitTask(taskCtx, &pb.TaskRequest{
-		TaskDescription: taskDescription,
-		Priority:        priority,
-	})
-	if err != nil {
-		log.Fatalf("could not submit task: %v", err)
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if c.apiKey != "" {
+		return map[string]string{"x-api-key": c.apiKey}, nil
 	}
-	log.Printf("Task submitted with ID: %s", res.TaskId)
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
 
-	// Stream the task status.
-	statusCtx, cancelStatus := context.WithCancel(context.Background())
-	defer cancelStatus()
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
 
-	stream, err := client.StreamTaskStatus(statusCtx, &pb.StatusRequest{TaskId: res.TaskId})
+// dial returns a client of the server with the configured transport and call
+// credentials. The connection is only made once the first call is sent.
+func (o *options) dial() (pb.TaskManagerClient, *grpc.ClientConn, error) {
+	creds, err := o.transportCredentials()
 	if err != nil {
-		log.Fatalf("could not get status stream: %v", err)
+		return nil, nil, fmt.Errorf("could not load TLS credentials: %w", err)
 	}
-
-	log.Println("Waiting for status updates...")
-	for {
-		status, err := stream.Recv()
-		if err != nil {
-			log.Printf("Stream finished: %v", err)
-			break
-		}
-		log.Printf("Task status [%s]: %s", res.TaskId, status.Status)
-		if status.Status == "COMPLETED" || status.Status == "FAILED" {
-			break
-		}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+	if o.apiKey != "" || o.bearerToken != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{apiKey: o.apiKey, token: o.bearerToken}))
+	}
+	conn, err := grpc.NewClient(o.server, opts...)
+	if err != nil {
+		return nil, nil, err
 	}
+	return pb.NewTaskManagerClient(conn), conn, nil
 }
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Statuses of tasks.
+const (
+	statusCompleted = "COMPLETED"
+	statusFailed    = "FAILED"
+	statusCancelled = "CANCELLED"
+	// statusUnknown is reported by CheckTaskStatus for tasks the server does
+	// not know or the caller may not see.
+	statusUnknown = "UNKNOWN TASK"
+)
+
+// isTerminal reports whether a task with the status is finished.
+func isTerminal(status string) bool {
+	return status == statusCompleted || status == statusFailed || status == statusCancelled
+}
+
+// outcome returns the error that makes taskctl exit with the status matching
+// the status of a task, or nil for tasks that completed or did not finish
+// yet.
+func outcome(taskID, status string) error {
+	switch status {
+	case statusFailed:
+		return &exitStatusError{code: exitFailed, err: fmt.Errorf("task %s failed", taskID)}
+	case statusCancelled:
+		return &exitStatusError{code: exitCancelled, err: fmt.Errorf("task %s was cancelled", taskID)}
+	case statusUnknown:
+		return &exitStatusError{code: exitNotFound, err: fmt.Errorf("task %s not found", taskID)}
+	}
+	return nil
+}
+
+// labelsFlag collects repeated key=value flags.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	var pairs []string
+	for _, k := range slices.Sorted(maps.Keys(l)) {
+		pairs = append(pairs, k+"="+l[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelsFlag) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return errors.New("labels must be given as key=value")
+	}
+	l[key] = value
+	return nil
+}
+
+// listFlag collects repeated or comma separated values, upper cased.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, strings.ToUpper(s))
+		}
+	}
+	return nil
+}
+
+// timeFlag is an optional RFC 3339 timestamp.
+type timeFlag struct{ t *timestamppb.Timestamp }
+
+func (f *timeFlag) String() string {
+	if f.t == nil {
+		return ""
+	}
+	return f.t.AsTime().Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(v string) error {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return errors.New("times must be given in RFC 3339 format, e.g. 2024-12-03T10:50:25Z")
+	}
+	f.t = timestamppb.New(t)
+	return nil
+}
+
+// argument returns the single argument of a command, named name in errors.
+func argument(args []string, name string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", usageError("expected a single %s argument", name)
+	}
+	return args[0], nil
+}
+
+func submitCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	priority := fs.String("priority", "", `priority of the task, "LOW", "MEDIUM" or "HIGH"; the default priority of the queue when empty`)
+	queue := fs.String("queue", "", `queue to submit the task to, "default" when empty`)
+	labels := labelsFlag{}
+	fs.Var(labels, "label", "label of the task as key=value, can be repeated")
+	wait := fs.Bool("wait", false, "follow the task until it finishes and exit with its outcome")
+	poll := fs.Duration("poll-interval", defaultPollInterval, "with -wait, how often the status is checked besides the status stream")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		description := strings.Join(args, " ")
+		if description == "" {
+			return usageError("missing task description")
+		}
+		if *wait && *poll <= 0 {
+			return usageError("-poll-interval must be positive")
+		}
+		res, err := e.client.SubmitTask(ctx, &pb.TaskRequest{
+			TaskDescription: description,
+			Priority:        strings.ToUpper(*priority),
+			Labels:          labels,
+			Queue:           *queue,
+		})
+		if err != nil {
+			return err
+		}
+		if !*wait {
+			return e.out.print(res, func(w io.Writer) {
+				fmt.Fprintln(w, res.TaskId)
+			})
+		}
+
+		// The ID is written like the status updates following it, e.g. as
+		// the first JSON line.
+		err = e.out.item(res, func(w io.Writer) {
+			fmt.Fprintln(w, res.TaskId)
+		})
+		if err != nil {
+			return err
+		}
+		return watch(ctx, e, res.TaskId, *poll)
+	}
+}
+
+func statusCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	history := fs.Bool("history", false, "also print the changes made to the task")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := argument(args, "task ID")
+		if err != nil {
+			return err
+		}
+		res, err := e.client.CheckTaskStatus(ctx, &pb.StatusRequest{TaskId: id})
+		if err != nil {
+			return err
+		}
+		if res.Status == statusUnknown {
+			return outcome(id, res.Status)
+		}
+		if !*history {
+			res.History = nil
+		}
+		err = e.out.print(res, func(w io.Writer) {
+			fmt.Fprintln(w, res.Status)
+			for _, event := range res.History {
+				fmt.Fprintf(w, "%s\t%s\n", event.Time.AsTime().Local().Format(time.RFC3339), event.Message)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return outcome(id, res.Status)
+	}
+}
+
+func watchCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	poll := fs.Duration("poll-interval", defaultPollInterval, "how often the status is checked besides the status stream")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := argument(args, "task ID")
+		if err != nil {
+			return err
+		}
+		if *poll <= 0 {
+			return usageError("-poll-interval must be positive")
+		}
+		return watch(ctx, e, id, *poll)
+	}
+}
+
+// watch prints the status of a task and every change of it until the task
+// finishes, and returns its outcome.
+func watch(ctx context.Context, e *env, id string, poll time.Duration) error {
+	last := ""
+	f := &follower{client: e.client, id: id, poll: poll, report: func(status string) error {
+		if status == last {
+			return nil
+		}
+		last = status
+		res := &pb.StatusResponse{Status: status}
+		return e.out.item(res, func(w io.Writer) {
+			fmt.Fprintf(w, "%s  %s\n", time.Now().Format(time.TimeOnly), status)
+		})
+	}}
+	final, err := f.run(ctx)
+	if err != nil {
+		return err
+	}
+	return outcome(id, final)
+}
+
+func statsCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	groupBy := fs.String("group-by-label", "", "also count the tasks per value of this label key")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) > 0 {
+			return usageError("unexpected arguments %q", args)
+		}
+		res, err := e.client.GetStatistics(ctx, &pb.StatisticsRequest{GroupByLabel: *groupBy})
+		if err != nil {
+			return err
+		}
+		return e.out.print(res, func(w io.Writer) { printStats(w, res, *groupBy) })
+	}
+}
+
+// printStats writes the statistics as tables.
+func printStats(w io.Writer, res *pb.StatisticsResponse, groupBy string) {
+	fmt.Fprintln(w, "\tQUEUED\tIN PROGRESS\tCOMPLETED\tFAILED\tCANCELLED")
+	row := func(name string, c *pb.StatusCounts) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", name, c.GetQueued(), c.GetInProgress(), c.GetCompleted(), c.GetFailed(), c.GetCancelled())
+	}
+	row("total", &pb.StatusCounts{
+		Queued:     res.Queued,
+		InProgress: res.InProgress,
+		Completed:  res.Completed,
+		Failed:     res.Failed,
+		Cancelled:  res.Cancelled,
+	})
+	groups := []struct {
+		prefix string
+		counts map[string]*pb.StatusCounts
+	}{
+		{"priority ", res.ByPriority},
+		{"queue ", res.ByQueue},
+		{groupBy + "=", res.ByLabel},
+	}
+	for _, g := range groups {
+		for _, k := range slices.Sorted(maps.Keys(g.counts)) {
+			row(g.prefix+k, g.counts[k])
+		}
+	}
+
+	fmt.Fprintln(w, "\nWINDOW\tCOMPLETED\tFAILED\tCANCELLED\tPER SECOND")
+	for _, t := range res.Throughput {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.2f\n", formatDuration(t.Window), t.Completed, t.Failed, t.Cancelled, t.PerSecond)
+	}
+
+	fmt.Fprintln(w, "\nLATENCY\tP50\tP95\tP99\tSAMPLES")
+	for _, l := range []struct {
+		name string
+		p    *pb.LatencyPercentiles
+	}{{"queue wait", res.QueueWait}, {"run time", res.RunDuration}} {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", l.name, formatDuration(l.p.GetP50()), formatDuration(l.p.GetP95()), formatDuration(l.p.GetP99()), l.p.GetSamples())
+	}
+
+	fmt.Fprintf(w, "\noldest queued\t%s\n", formatDuration(res.OldestQueuedAge))
+	switch {
+	case res.Paused:
+		fmt.Fprintln(w, "paused\tall queues")
+	case len(res.PausedQueues) > 0:
+		fmt.Fprintf(w, "paused\t%s\n", strings.Join(res.PausedQueues, ", "))
+	}
+}
+
+// formatDuration returns the duration rounded to milliseconds, or "-" when it
+// is unset.
+func formatDuration(d *durationpb.Duration) string {
+	if d == nil {
+		return "-"
+	}
+	return d.AsDuration().Round(time.Millisecond).String()
+}
+
+func cancelCommand(fs *flag.FlagSet) func(context.Context, *env, []string) error {
+	var statuses, priorities listFlag
+	var after, before timeFlag
+	labels := labelsFlag{}
+	fs.Var(&statuses, "status", "only cancel tasks with this status, can be repeated or comma separated")
+	fs.Var(&priorities, "priority", "only cancel tasks with this priority, can be repeated or comma separated")
+	fs.Var(labels, "label", "only cancel tasks carrying this label, as key=value, can be repeated")
+	fs.Var(&after, "created-after", "only cancel tasks submitted at or after this RFC 3339 time")
+	fs.Var(&before, "created-before", "only cancel tasks submitted before this RFC 3339 time")
+	all := fs.Bool("all", false, "cancel every task when no filter is given")
+	dryRun := fs.Bool("dry-run", false, "only report the number of matching tasks")
+
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) > 0 {
+			return usageError("unexpected arguments %q", args)
+		}
+		filter := &pb.TaskFilter{
+			Statuses:      statuses,
+			Priorities:    priorities,
+			Labels:        labels,
+			CreatedAfter:  after.t,
+			CreatedBefore: before.t,
+		}
+		empty := len(statuses) == 0 && len(priorities) == 0 && len(labels) == 0 && after.t == nil && before.t == nil
+		if empty && !*all {
+			return usageError("no filter given, use -all to cancel every task")
+		}
+
+		stream, err := e.client.BulkOperation(ctx, &pb.BulkOperationRequest{
+			Filter: filter,
+			Action: "CANCEL",
+			DryRun: *dryRun,
+		})
+		if err != nil {
+			return err
+		}
+		var progress *pb.BulkOperationProgress
+		for {
+			progress, err = stream.Recv()
+			if err != nil {
+				return err
+			}
+			if progress.Done {
+				break
+			}
+		}
+		return e.out.print(progress, func(w io.Writer) {
+			if *dryRun {
+				fmt.Fprintf(w, "matched\t%d\n", progress.Matched)
+				return
+			}
+			fmt.Fprintf(w, "matched\t%d\ncancelled\t%d\nskipped\t%d\n", progress.Matched, progress.Succeeded, progress.Skipped)
+		})
+	}
+}
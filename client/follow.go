@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultPollInterval is how often the status of a followed task is
+	// checked, on top of the updates of its status stream.
+	defaultPollInterval = 5 * time.Second
+	// Reopening a dropped status stream is delayed by minReconnectDelay,
+	// doubled after every drop up to the poll interval.
+	minReconnectDelay = 100 * time.Millisecond
+)
+
+// follower follows the status of a task until it finishes. Status changes
+// are taken from StreamTaskStatus, which is reopened whenever it drops. The
+// status is also checked with CheckTaskStatus every poll interval, so the
+// task is followed by polling alone while the stream cannot be opened, and
+// an update missed while the stream was down is still seen.
+type follower struct {
+	client pb.TaskManagerClient
+	id     string
+	poll   time.Duration
+	// report is called with every status of the task, the same status may
+	// be reported more than once.
+	report func(status string) error
+}
+
+// run follows the task and returns its final status, statusUnknown when the
+// server does not know it, or an error when ctx ends first.
+func (f *follower) run(ctx context.Context) (string, error) {
+	delay := minReconnectDelay
+	for {
+		current, err := f.check(ctx)
+		if err != nil {
+			return "", err
+		}
+		if current == statusUnknown || isTerminal(current) {
+			return current, nil
+		}
+
+		// Only poll while the server cannot be reached.
+		if current != "" {
+			final, err := f.stream(ctx)
+			if err != nil || final != "" {
+				return final, err
+			}
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", f.ctxErr(ctx)
+		}
+		delay = min(2*delay, max(f.poll, minReconnectDelay))
+	}
+}
+
+// check returns the current status of the task. Errors that may go away on
+// a retry, e.g. while the server restarts, are reported as an empty status.
+func (f *follower) check(ctx context.Context) (string, error) {
+	res, err := f.client.CheckTaskStatus(ctx, &pb.StatusRequest{TaskId: f.id})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", f.ctxErr(ctx)
+		}
+		if !retryable(err) {
+			return "", err
+		}
+		slog.DebugContext(ctx, "could not check task status", "task_id", f.id, "error", err)
+		return "", nil
+	}
+	if res.Status != statusUnknown {
+		if err := f.report(res.Status); err != nil {
+			return "", err
+		}
+	}
+	return res.Status, nil
+}
+
+// stream follows one status stream, polling the status alongside it, until
+// the task finishes or the stream ends. It returns the final status, or an
+// empty status when the stream ended before the task finished.
+func (f *follower) stream(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	updates := make(chan string)
+	ended := make(chan error, 1)
+	go func() {
+		stream, err := f.client.StreamTaskStatus(ctx, &pb.StatusRequest{TaskId: f.id})
+		for err == nil {
+			var update *pb.StatusResponse
+			if update, err = stream.Recv(); err != nil {
+				break
+			}
+			select {
+			case updates <- update.Status:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		ended <- err
+	}()
+
+	ticker := time.NewTicker(f.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case update := <-updates:
+			if err := f.report(update); err != nil {
+				return "", err
+			}
+			if isTerminal(update) {
+				return update, nil
+			}
+		case <-ticker.C:
+			current, err := f.check(ctx)
+			if err != nil {
+				return "", err
+			}
+			if current == statusUnknown || isTerminal(current) {
+				return current, nil
+			}
+		case err := <-ended:
+			if ctx.Err() != nil {
+				return "", f.ctxErr(ctx)
+			}
+			slog.WarnContext(ctx, "status stream dropped, reconnecting", "task_id", f.id, "error", err)
+			return "", nil
+		case <-ctx.Done():
+			return "", f.ctxErr(ctx)
+		}
+	}
+}
+
+// ctxErr returns why ctx ended, or nil if it did not.
+func (f *follower) ctxErr(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("task %s did not finish in time: %w", f.id, ctx.Err())
+}
+
+// retryable reports whether a failed call may succeed when sent again, e.g.
+// after the server restarted or the connection was lost.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
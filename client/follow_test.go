@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const statusInProgress = "IN_PROGRESS"
+
+// restartingServer serves a task that is IN_PROGRESS until the first status
+// stream drops, as if the server restarted. Right after the drop the server
+// cannot be reached for one CheckTaskStatus call. The next stream then
+// reports the final status.
+type restartingServer struct {
+	pb.UnimplementedTaskManagerServer
+	// final is the status the task ends in; statusUnknown when the restart
+	// loses it and empty when it never finishes.
+	final string
+	// streamDown makes every StreamTaskStatus call fail, so the task can
+	// only be followed by polling.
+	streamDown bool
+
+	mu      sync.Mutex
+	streams int
+	down    int
+	checks  int
+}
+
+func (s *restartingServer) CheckTaskStatus(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks++
+	if s.down > 0 {
+		s.down--
+		return nil, status.Error(codes.Unavailable, "server restarting")
+	}
+	switch {
+	case s.final == statusUnknown && s.streams > 0:
+		return &pb.StatusResponse{Status: statusUnknown}, nil
+	case s.streamDown && s.checks > 3 && s.final != "":
+		return &pb.StatusResponse{Status: s.final}, nil
+	case s.streams > 1 && s.final != "":
+		return &pb.StatusResponse{Status: s.final}, nil
+	}
+	return &pb.StatusResponse{Status: statusInProgress}, nil
+}
+
+func (s *restartingServer) StreamTaskStatus(req *pb.StatusRequest, stream pb.TaskManager_StreamTaskStatusServer) error {
+	s.mu.Lock()
+	s.streams++
+	n := s.streams
+	if n == 1 {
+		s.down = 1
+	}
+	s.mu.Unlock()
+
+	if s.streamDown {
+		return status.Error(codes.Unavailable, "status streams unavailable")
+	}
+	if err := stream.Send(&pb.StatusResponse{Status: statusInProgress}); err != nil {
+		return err
+	}
+	if n == 1 {
+		// Drop the stream in the middle of the task.
+		return status.Error(codes.Unavailable, "server restarting")
+	}
+	if s.final == "" {
+		<-stream.Context().Done()
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+	return stream.Send(&pb.StatusResponse{Status: s.final})
+}
+
+// dialFake serves the server over an in-memory listener and returns a client
+// of it.
+func dialFake(t *testing.T, srv pb.TaskManagerServer) pb.TaskManagerClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	pb.RegisterTaskManagerServer(grpcServer, srv)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewTaskManagerClient(conn)
+}
+
+func TestWatchAcrossDroppedStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		final      string
+		streamDown bool
+		// reported are the statuses printed, wantCode the exit status.
+		reported []string
+		wantCode int
+	}{
+		{"completed", statusCompleted, false, []string{statusInProgress, statusCompleted}, exitOK},
+		{"failed", statusFailed, false, []string{statusInProgress, statusFailed}, exitFailed},
+		{"cancelled", statusCancelled, false, []string{statusInProgress, statusCancelled}, exitCancelled},
+		{"lost in restart", statusUnknown, false, []string{statusInProgress}, exitNotFound},
+		{"never finishes", "", false, []string{statusInProgress}, exitTimeout},
+		{"polled only", statusFailed, true, []string{statusInProgress, statusFailed}, exitFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &restartingServer{final: tt.final, streamDown: tt.streamDown}
+			var out bytes.Buffer
+			p, err := newPrinter(&out, outputJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := &env{client: dialFake(t, srv), out: p}
+
+			// Updates come from the stream unless it is down, so the poll
+			// interval only matters then.
+			poll := time.Minute
+			if tt.streamDown {
+				poll = 20 * time.Millisecond
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			err = watch(ctx, e, "42", poll)
+			if got := exitCode(err); got != tt.wantCode {
+				t.Errorf("watch returned %v, exit status %d, want %d", err, got, tt.wantCode)
+			}
+
+			var reported []string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var res struct{ Status string }
+				if err := json.Unmarshal([]byte(line), &res); err != nil {
+					t.Fatalf("output line %q: %v", line, err)
+				}
+				reported = append(reported, res.Status)
+			}
+			if !slices.Equal(reported, tt.reported) {
+				t.Errorf("reported %q, want %q", reported, tt.reported)
+			}
+
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			if !tt.streamDown && tt.final != statusUnknown && srv.streams != 2 {
+				t.Errorf("opened %d status streams, want 2", srv.streams)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	<-expired.Done()
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{outcome("42", statusCompleted), exitOK},
+		{outcome("42", statusFailed), exitFailed},
+		{outcome("42", statusCancelled), exitCancelled},
+		{outcome("42", statusUnknown), exitNotFound},
+		{usageError("bad flag"), exitUsage},
+		{status.Error(codes.NotFound, "queue not found"), exitNotFound},
+		{status.Error(codes.DeadlineExceeded, "deadline exceeded"), exitTimeout},
+		{(&follower{id: "42"}).ctxErr(expired), exitTimeout},
+		{status.Error(codes.Unavailable, "connection refused"), exitError},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	// The server cannot list tasks, which both the usage and the list
+	// command itself explain.
+	for _, args := range [][]string{{"help"}, {"list"}} {
+		var stderr bytes.Buffer
+		run(args, &bytes.Buffer{}, &stderr)
+		if !strings.Contains(stderr.String(), noListCommand) {
+			t.Errorf("taskctl %s wrote %q, want it to explain there is no list command", args[0], stderr.String())
+		}
+	}
+	if code := run([]string{"list"}, &bytes.Buffer{}, &bytes.Buffer{}); code != exitUsage {
+		t.Errorf("taskctl list exited with %d, want %d", code, exitUsage)
+	}
+}
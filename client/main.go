@@ -0,0 +1,289 @@
+// Command taskctl is the command-line client of the task manager. It submits
+// tasks, reports and follows their status, prints statistics and cancels
+// tasks in bulk:
+//
+//	taskctl [flags] <command> [command flags] [arguments]
+//
+// Run "taskctl help" for the list of commands. The exit status reflects the
+// outcome, see the exit* constants, so taskctl can be used in shell scripts
+// and CI pipelines.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/maciekb2/task-manager/logging"
+	pb "github.com/maciekb2/task-manager/proto"
+	"github.com/maciekb2/task-manager/telemetry"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit statuses of taskctl.
+const (
+	exitOK = 0
+	// exitError reports a failed command, e.g. an unreachable server or a
+	// rejected request.
+	exitError = 1
+	// exitUsage reports invalid flags or arguments.
+	exitUsage = 2
+	// exitFailed and exitCancelled report a task that ended FAILED or
+	// CANCELLED.
+	exitFailed    = 3
+	exitCancelled = 4
+	// exitNotFound reports a task, queue or tenant the server does not know.
+	exitNotFound = 5
+	// exitTimeout reports a command that did not finish within -timeout.
+	exitTimeout = 6
+)
+
+// exitStatusError is an error that makes taskctl exit with a specific status.
+type exitStatusError struct {
+	code int
+	err  error
+}
+
+func (e *exitStatusError) Error() string { return e.err.Error() }
+
+func (e *exitStatusError) Unwrap() error { return e.err }
+
+// usageError returns an error that exits with exitUsage.
+func usageError(format string, args ...any) error {
+	return &exitStatusError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the exit status for the error of a command.
+func exitCode(err error) int {
+	var e *exitStatusError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &e):
+		return e.code
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return exitNotFound
+	case codes.DeadlineExceeded:
+		return exitTimeout
+	}
+	return exitError
+}
+
+// env is what a command runs with.
+type env struct {
+	client pb.TaskManagerClient
+	out    *printer
+}
+
+// command is a subcommand of taskctl.
+type command struct {
+	name    string
+	args    string
+	summary string
+	// setup registers the flags of the command and returns the function
+	// running it with the remaining arguments.
+	setup func(fs *flag.FlagSet) func(ctx context.Context, e *env, args []string) error
+	// follows reports whether the command, with its parsed flags, follows a
+	// task until it finishes; nil for commands that never do.
+	follows func(fs *flag.FlagSet) bool
+}
+
+var commands = []command{
+	{"submit", "[-priority P] [-queue Q] [-label k=v]... [-wait] <description>", "submit a task and print its ID, or wait for it to finish", submitCommand, whenFlag("wait")},
+	{"status", "[-history] <task-id>", "print the status of a task", statusCommand, nil},
+	{"watch", "[-poll-interval d] <task-id>", "follow the status of a task until it finishes", watchCommand, always},
+	{"stats", "[-group-by-label key]", "print task statistics", statsCommand, nil},
+	{"cancel", "[filter flags] [-all] [-dry-run]", "cancel the tasks matching a filter", cancelCommand, nil},
+}
+
+// noListCommand explains why there is no list command.
+const noListCommand = `there is no list command, the server has no call listing tasks; "stats" and "cancel -dry-run" report how many tasks there are`
+
+// always is the follows function of commands that always follow a task.
+func always(*flag.FlagSet) bool { return true }
+
+// whenFlag returns a follows function reporting whether the named boolean
+// flag is true.
+func whenFlag(name string) func(fs *flag.FlagSet) bool {
+	return func(fs *flag.FlagSet) bool {
+		return fs.Lookup(name).Value.String() == "true"
+	}
+}
+
+// isSet reports whether the named flag was given in one of the flag sets.
+func isSet(name string, sets ...*flag.FlagSet) bool {
+	set := false
+	for _, fs := range sets {
+		fs.Visit(func(f *flag.Flag) {
+			set = set || f.Name == name
+		})
+	}
+	return set
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs taskctl with the arguments and returns its exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	opts := defaultOptions()
+	global := flag.NewFlagSet("taskctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	opts.register(global)
+	global.Usage = func() { usage(global) }
+	if err := global.Parse(args); err != nil {
+		return exitCode(usageError("%w", err))
+	}
+	if global.NArg() == 0 || global.Arg(0) == "help" {
+		global.Usage()
+		if global.NArg() == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	name := global.Arg(0)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil && name == "list" {
+		fmt.Fprintln(stderr, "taskctl: "+noListCommand)
+		return exitUsage
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "taskctl: unknown command %q, run \"taskctl help\" for the list of commands\n", name)
+		return exitUsage
+	}
+
+	// The shared flags can also follow the command name.
+	fs := flag.NewFlagSet("taskctl "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.register(fs)
+	runCmd := cmd.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: taskctl %s %s\n\n%s.\n\nflags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(global.Args()[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if !isSet("timeout", global, fs) {
+		opts.timeout = defaultTimeout
+		if cmd.follows != nil && cmd.follows(fs) {
+			opts.timeout = 0
+		}
+	}
+
+	ctx := logging.NewContext(context.Background())
+	err := execute(ctx, &opts, name, runCmd, fs.Args(), stdout, stderr)
+	code := exitCode(err)
+	if err != nil && code != exitFailed && code != exitCancelled {
+		fmt.Fprintf(stderr, "taskctl: %s: %v\n", name, err)
+	}
+	if code == exitUsage {
+		fs.Usage()
+	}
+	return code
+}
+
+// execute sets up logging, telemetry and the connection to the server, then
+// runs the command.
+func execute(ctx context.Context, opts *options, name string, runCmd func(context.Context, *env, []string) error, args []string, stdout, stderr io.Writer) error {
+	if err := setupLogging(opts, stderr); err != nil {
+		return usageError("%w", err)
+	}
+	out, err := newPrinter(stdout, opts.output)
+	if err != nil {
+		return usageError("%w", err)
+	}
+
+	// Initialize OpenTelemetry from the OTEL_* environment.
+	providers, err := telemetry.Setup(ctx, "taskctl")
+	if err != nil {
+		return fmt.Errorf("failed to set up telemetry: %w", err)
+	}
+	defer func() {
+		// ctx is done by now once -timeout applies.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := providers.Shutdown(ctx); err != nil {
+			slog.WarnContext(ctx, "failed to flush telemetry", "error", err)
+		}
+	}()
+
+	// The calls of a command share one trace, so its ID in the log lines
+	// matches the one logged by the server.
+	ctx, span := otel.Tracer("taskctl").Start(ctx, "taskctl "+name)
+	defer span.End()
+
+	client, conn, err := opts.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	return runCmd(ctx, &env{client: client, out: out}, args)
+}
+
+// setupLogging makes a logger with the configured format and level the
+// default one. Logs go to stderr, so they do not mix with the output.
+func setupLogging(opts *options, w io.Writer) error {
+	level, err := logging.ParseLevel(opts.logLevel)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", opts.logLevel)
+	}
+	var v slog.LevelVar
+	v.Set(level)
+	logger, err := logging.New(w, opts.logFormat, &v)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// usage prints the commands and the shared flags.
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprint(w, "usage: taskctl [flags] <command> [command flags] [arguments]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nNote: %s.\n", noListCommand)
+	fmt.Fprint(w, "\nRun \"taskctl <command> -h\" for the flags of a command.\n\nflags:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(w, `
+exit status:
+  %d  success, or the task completed
+  %d  error
+  %d  invalid flags or arguments
+  %d  the task failed
+  %d  the task was cancelled
+  %d  the task was not found
+  %d  the command timed out
+`, exitOK, exitError, exitUsage, exitFailed, exitCancelled, exitNotFound, exitTimeout)
+}
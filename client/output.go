@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// printer writes the results of a command in the selected format. Messages
+// are written as JSON or YAML with the field names of the proto files, or as
+// tables meant to be read by people.
+type printer struct {
+	w      io.Writer
+	format string
+	// items is the number of messages written by item.
+	items int
+}
+
+// newPrinter returns a printer writing to w, or an error if the format is
+// unknown.
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return &printer{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unsupported output format %q", format)
+}
+
+// print writes a message, using table to write it in the table format.
+func (p *printer) print(msg proto.Message, table func(w io.Writer)) error {
+	switch p.format {
+	case outputJSON:
+		b, err := marshalJSON(msg)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		json.Indent(&buf, b, "", "  ")
+		buf.WriteByte('\n')
+		_, err = buf.WriteTo(p.w)
+		return err
+	case outputYAML:
+		return p.writeYAML(msg)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// item writes one message of a stream: a line of the table, a line of JSON or
+// a YAML document.
+func (p *printer) item(msg proto.Message, line func(w io.Writer)) error {
+	defer func() { p.items++ }()
+	switch p.format {
+	case outputJSON:
+		b, err := marshalJSON(msg)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		json.Compact(&buf, b)
+		buf.WriteByte('\n')
+		_, err = buf.WriteTo(p.w)
+		return err
+	case outputYAML:
+		if p.items > 0 {
+			fmt.Fprintln(p.w, "---")
+		}
+		return p.writeYAML(msg)
+	}
+	line(p.w)
+	return nil
+}
+
+func (p *printer) writeYAML(msg proto.Message) error {
+	b, err := marshalJSON(msg)
+	if err != nil {
+		return err
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(p.w)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// marshalJSON converts a message to JSON with the field names of the proto
+// files.
+func marshalJSON(msg proto.Message) ([]byte, error) {
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+}
@@ -0,0 +1,102 @@
+// Package logging sets up structured logging for the task manager binaries.
+// Log lines written with a context carry the trace and span IDs of the
+// context and the attributes attached to it with NewContext and With.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a logger writing lines in the format to w, at the level of the
+// variable so it can be changed while running.
+func New(w io.Writer, format string, level *slog.LevelVar) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", format)
+	}
+	return slog.New(Handler{h}), nil
+}
+
+// ParseLevel parses a level name such as "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// attrSet collects the attributes of one request or task. It is shared by
+// every context derived from the one it was attached to.
+type attrSet struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+type attrSetKey struct{}
+
+// NewContext returns a context with a new set of attributes, e.g. for one
+// request or one run of a task, holding the given ones.
+func NewContext(ctx context.Context, attrs ...slog.Attr) context.Context {
+	return context.WithValue(ctx, attrSetKey{}, &attrSet{attrs: attrs})
+}
+
+// With adds attributes to the set of the context, so they are logged with
+// every later line of the request or task, including lines written with a
+// parent context that shares the set. Without a set, a new one is attached.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	set, ok := ctx.Value(attrSetKey{}).(*attrSet)
+	if !ok {
+		return NewContext(ctx, attrs...)
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.attrs = append(set.attrs, attrs...)
+	return ctx
+}
+
+// Handler adds the trace and span IDs and the attribute set of the context
+// to every record.
+type Handler struct {
+	slog.Handler
+}
+
+func (h Handler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	if set, ok := ctx.Value(attrSetKey{}).(*attrSet); ok {
+		set.mu.Lock()
+		r.AddAttrs(set.attrs...)
+		set.mu.Unlock()
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return Handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h Handler) WithGroup(name string) slog.Handler {
+	return Handler{h.Handler.WithGroup(name)}
+}
@@ -19,22 +19,74 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	TaskManager_SubmitTask_FullMethodName       = "/taskmanager.TaskManager/SubmitTask"
-	TaskManager_CheckTaskStatus_FullMethodName  = "/taskmanager.TaskManager/CheckTaskStatus"
-	TaskManager_StreamTaskStatus_FullMethodName = "/taskmanager.TaskManager/StreamTaskStatus"
-	TaskManager_GetStatistics_FullMethodName    = "/taskmanager.TaskManager/GetStatistics"
+	TaskManager_SubmitTask_FullMethodName           = "/taskmanager.TaskManager/SubmitTask"
+	TaskManager_CheckTaskStatus_FullMethodName      = "/taskmanager.TaskManager/CheckTaskStatus"
+	TaskManager_StreamTaskStatus_FullMethodName     = "/taskmanager.TaskManager/StreamTaskStatus"
+	TaskManager_GetStatistics_FullMethodName        = "/taskmanager.TaskManager/GetStatistics"
+	TaskManager_GetStatisticsHistory_FullMethodName = "/taskmanager.TaskManager/GetStatisticsHistory"
+	TaskManager_BulkOperation_FullMethodName        = "/taskmanager.TaskManager/BulkOperation"
+	TaskManager_UpdateTask_FullMethodName           = "/taskmanager.TaskManager/UpdateTask"
+	TaskManager_CreateQueue_FullMethodName          = "/taskmanager.TaskManager/CreateQueue"
+	TaskManager_ListQueues_FullMethodName           = "/taskmanager.TaskManager/ListQueues"
+	TaskManager_UpdateQueue_FullMethodName          = "/taskmanager.TaskManager/UpdateQueue"
+	TaskManager_PauseQueue_FullMethodName           = "/taskmanager.TaskManager/PauseQueue"
+	TaskManager_ResumeQueue_FullMethodName          = "/taskmanager.TaskManager/ResumeQueue"
+	TaskManager_DrainQueue_FullMethodName           = "/taskmanager.TaskManager/DrainQueue"
+	TaskManager_PauseProcessing_FullMethodName      = "/taskmanager.TaskManager/PauseProcessing"
+	TaskManager_ResumeProcessing_FullMethodName     = "/taskmanager.TaskManager/ResumeProcessing"
+	TaskManager_CreateTenant_FullMethodName         = "/taskmanager.TaskManager/CreateTenant"
+	TaskManager_ListTenants_FullMethodName          = "/taskmanager.TaskManager/ListTenants"
+	TaskManager_GetTenantUsage_FullMethodName       = "/taskmanager.TaskManager/GetTenantUsage"
+	TaskManager_QueryAuditLog_FullMethodName        = "/taskmanager.TaskManager/QueryAuditLog"
 )
 
 // TaskManagerClient is the client API for TaskManager service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 //
-// Definicja serwisu
+// TaskManager service definition.
 type TaskManagerClient interface {
+	// Submits a new task to the task manager.
 	SubmitTask(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskResponse, error)
+	// Checks the current status of a task.
 	CheckTaskStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Streams the status of a task in real-time.
 	StreamTaskStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StatusResponse], error)
+	// Returns the number of tasks in each status.
 	GetStatistics(ctx context.Context, in *StatisticsRequest, opts ...grpc.CallOption) (*StatisticsResponse, error)
+	// Returns the number of tasks submitted and finished over time.
+	GetStatisticsHistory(ctx context.Context, in *StatisticsHistoryRequest, opts ...grpc.CallOption) (*StatisticsHistoryResponse, error)
+	// Applies an action to every task matching a filter, streaming progress
+	// until the operation is done.
+	BulkOperation(ctx context.Context, in *BulkOperationRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BulkOperationProgress], error)
+	// Changes the fields of a task that is still queued.
+	UpdateTask(ctx context.Context, in *UpdateTaskRequest, opts ...grpc.CallOption) (*TaskResponse, error)
+	// Admin: creates a new named queue.
+	CreateQueue(ctx context.Context, in *CreateQueueRequest, opts ...grpc.CallOption) (*Queue, error)
+	// Admin: lists all queues with their configuration and state.
+	ListQueues(ctx context.Context, in *ListQueuesRequest, opts ...grpc.CallOption) (*ListQueuesResponse, error)
+	// Admin: changes the configuration of a queue.
+	UpdateQueue(ctx context.Context, in *UpdateQueueRequest, opts ...grpc.CallOption) (*Queue, error)
+	// Admin: stops starting new tasks from a queue. Running tasks finish.
+	PauseQueue(ctx context.Context, in *QueueRequest, opts ...grpc.CallOption) (*Queue, error)
+	// Admin: resumes a paused or draining queue.
+	ResumeQueue(ctx context.Context, in *QueueRequest, opts ...grpc.CallOption) (*Queue, error)
+	// Admin: stops accepting new tasks into a queue while its backlog is processed.
+	DrainQueue(ctx context.Context, in *QueueRequest, opts ...grpc.CallOption) (*Queue, error)
+	// Admin: stops starting new tasks on the whole server. Tasks are still
+	// accepted and running tasks finish. Use PauseQueue for a single queue.
+	PauseProcessing(ctx context.Context, in *ProcessingRequest, opts ...grpc.CallOption) (*ProcessingState, error)
+	// Admin: resumes starting tasks on the whole server. Use ResumeQueue for
+	// a single queue.
+	ResumeProcessing(ctx context.Context, in *ProcessingRequest, opts ...grpc.CallOption) (*ProcessingState, error)
+	// Admin: creates a tenant with its own queues and limits.
+	CreateTenant(ctx context.Context, in *CreateTenantRequest, opts ...grpc.CallOption) (*Tenant, error)
+	// Admin: lists all tenants.
+	ListTenants(ctx context.Context, in *ListTenantsRequest, opts ...grpc.CallOption) (*ListTenantsResponse, error)
+	// Admin: reports the quota usage of a tenant.
+	GetTenantUsage(ctx context.Context, in *TenantUsageRequest, opts ...grpc.CallOption) (*TenantUsage, error)
+	// Admin: returns audit records of mutating calls matching a filter.
+	QueryAuditLog(ctx context.Context, in *AuditLogRequest, opts ...grpc.CallOption) (*AuditLogResponse, error)
 }
 
 type taskManagerClient struct {
@@ -81,6 +133,9 @@ func (c *taskManagerClient) StreamTaskStatus(ctx context.Context, in *StatusRequ
 	return x, nil
 }
 
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TaskManager_StreamTaskStatusClient = grpc.ServerStreamingClient[StatusResponse]
+
 func (c *taskManagerClient) GetStatistics(ctx context.Context, in *StatisticsRequest, opts ...grpc.CallOption) (*StatisticsResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(StatisticsResponse)
@@ -91,19 +146,212 @@ func (c *taskManagerClient) GetStatistics(ctx context.Context, in *StatisticsReq
 	return out, nil
 }
 
+func (c *taskManagerClient) GetStatisticsHistory(ctx context.Context, in *StatisticsHistoryRequest, opts ...grpc.CallOption) (*StatisticsHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatisticsHistoryResponse)
+	err := c.cc.Invoke(ctx, TaskManager_GetStatisticsHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) BulkOperation(ctx context.Context, in *BulkOperationRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BulkOperationProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TaskManager_ServiceDesc.Streams[1], TaskManager_BulkOperation_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BulkOperationRequest, BulkOperationProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
-type TaskManager_StreamTaskStatusClient = grpc.ServerStreamingClient[StatusResponse]
+type TaskManager_BulkOperationClient = grpc.ServerStreamingClient[BulkOperationProgress]
+
+func (c *taskManagerClient) UpdateTask(ctx context.Context, in *UpdateTaskRequest, opts ...grpc.CallOption) (*TaskResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TaskResponse)
+	err := c.cc.Invoke(ctx, TaskManager_UpdateTask_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) CreateQueue(ctx context.Context, in *CreateQueueRequest, opts ...grpc.CallOption) (*Queue, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Queue)
+	err := c.cc.Invoke(ctx, TaskManager_CreateQueue_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) ListQueues(ctx context.Context, in *ListQueuesRequest, opts ...grpc.CallOption) (*ListQueuesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListQueuesResponse)
+	err := c.cc.Invoke(ctx, TaskManager_ListQueues_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) UpdateQueue(ctx context.Context, in *UpdateQueueRequest, opts ...grpc.CallOption) (*Queue, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Queue)
+	err := c.cc.Invoke(ctx, TaskManager_UpdateQueue_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) PauseQueue(ctx context.Context, in *QueueRequest, opts ...grpc.CallOption) (*Queue, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Queue)
+	err := c.cc.Invoke(ctx, TaskManager_PauseQueue_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) ResumeQueue(ctx context.Context, in *QueueRequest, opts ...grpc.CallOption) (*Queue, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Queue)
+	err := c.cc.Invoke(ctx, TaskManager_ResumeQueue_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) DrainQueue(ctx context.Context, in *QueueRequest, opts ...grpc.CallOption) (*Queue, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Queue)
+	err := c.cc.Invoke(ctx, TaskManager_DrainQueue_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) PauseProcessing(ctx context.Context, in *ProcessingRequest, opts ...grpc.CallOption) (*ProcessingState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProcessingState)
+	err := c.cc.Invoke(ctx, TaskManager_PauseProcessing_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) ResumeProcessing(ctx context.Context, in *ProcessingRequest, opts ...grpc.CallOption) (*ProcessingState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProcessingState)
+	err := c.cc.Invoke(ctx, TaskManager_ResumeProcessing_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) CreateTenant(ctx context.Context, in *CreateTenantRequest, opts ...grpc.CallOption) (*Tenant, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Tenant)
+	err := c.cc.Invoke(ctx, TaskManager_CreateTenant_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) ListTenants(ctx context.Context, in *ListTenantsRequest, opts ...grpc.CallOption) (*ListTenantsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTenantsResponse)
+	err := c.cc.Invoke(ctx, TaskManager_ListTenants_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) GetTenantUsage(ctx context.Context, in *TenantUsageRequest, opts ...grpc.CallOption) (*TenantUsage, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TenantUsage)
+	err := c.cc.Invoke(ctx, TaskManager_GetTenantUsage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskManagerClient) QueryAuditLog(ctx context.Context, in *AuditLogRequest, opts ...grpc.CallOption) (*AuditLogResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AuditLogResponse)
+	err := c.cc.Invoke(ctx, TaskManager_QueryAuditLog_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
 
 // TaskManagerServer is the server API for TaskManager service.
 // All implementations must embed UnimplementedTaskManagerServer
 // for forward compatibility.
 //
-// Definicja serwisu
+// TaskManager service definition.
 type TaskManagerServer interface {
+	// Submits a new task to the task manager.
 	SubmitTask(context.Context, *TaskRequest) (*TaskResponse, error)
+	// Checks the current status of a task.
 	CheckTaskStatus(context.Context, *StatusRequest) (*StatusResponse, error)
+	// Streams the status of a task in real-time.
 	StreamTaskStatus(*StatusRequest, grpc.ServerStreamingServer[StatusResponse]) error
+	// Returns the number of tasks in each status.
 	GetStatistics(context.Context, *StatisticsRequest) (*StatisticsResponse, error)
+	// Returns the number of tasks submitted and finished over time.
+	GetStatisticsHistory(context.Context, *StatisticsHistoryRequest) (*StatisticsHistoryResponse, error)
+	// Applies an action to every task matching a filter, streaming progress
+	// until the operation is done.
+	BulkOperation(*BulkOperationRequest, grpc.ServerStreamingServer[BulkOperationProgress]) error
+	// Changes the fields of a task that is still queued.
+	UpdateTask(context.Context, *UpdateTaskRequest) (*TaskResponse, error)
+	// Admin: creates a new named queue.
+	CreateQueue(context.Context, *CreateQueueRequest) (*Queue, error)
+	// Admin: lists all queues with their configuration and state.
+	ListQueues(context.Context, *ListQueuesRequest) (*ListQueuesResponse, error)
+	// Admin: changes the configuration of a queue.
+	UpdateQueue(context.Context, *UpdateQueueRequest) (*Queue, error)
+	// Admin: stops starting new tasks from a queue. Running tasks finish.
+	PauseQueue(context.Context, *QueueRequest) (*Queue, error)
+	// Admin: resumes a paused or draining queue.
+	ResumeQueue(context.Context, *QueueRequest) (*Queue, error)
+	// Admin: stops accepting new tasks into a queue while its backlog is processed.
+	DrainQueue(context.Context, *QueueRequest) (*Queue, error)
+	// Admin: stops starting new tasks on the whole server. Tasks are still
+	// accepted and running tasks finish. Use PauseQueue for a single queue.
+	PauseProcessing(context.Context, *ProcessingRequest) (*ProcessingState, error)
+	// Admin: resumes starting tasks on the whole server. Use ResumeQueue for
+	// a single queue.
+	ResumeProcessing(context.Context, *ProcessingRequest) (*ProcessingState, error)
+	// Admin: creates a tenant with its own queues and limits.
+	CreateTenant(context.Context, *CreateTenantRequest) (*Tenant, error)
+	// Admin: lists all tenants.
+	ListTenants(context.Context, *ListTenantsRequest) (*ListTenantsResponse, error)
+	// Admin: reports the quota usage of a tenant.
+	GetTenantUsage(context.Context, *TenantUsageRequest) (*TenantUsage, error)
+	// Admin: returns audit records of mutating calls matching a filter.
+	QueryAuditLog(context.Context, *AuditLogRequest) (*AuditLogResponse, error)
 	mustEmbedUnimplementedTaskManagerServer()
 }
 
@@ -126,6 +374,51 @@ func (UnimplementedTaskManagerServer) StreamTaskStatus(*StatusRequest, grpc.Serv
 func (UnimplementedTaskManagerServer) GetStatistics(context.Context, *StatisticsRequest) (*StatisticsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetStatistics not implemented")
 }
+func (UnimplementedTaskManagerServer) GetStatisticsHistory(context.Context, *StatisticsHistoryRequest) (*StatisticsHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatisticsHistory not implemented")
+}
+func (UnimplementedTaskManagerServer) BulkOperation(*BulkOperationRequest, grpc.ServerStreamingServer[BulkOperationProgress]) error {
+	return status.Errorf(codes.Unimplemented, "method BulkOperation not implemented")
+}
+func (UnimplementedTaskManagerServer) UpdateTask(context.Context, *UpdateTaskRequest) (*TaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateTask not implemented")
+}
+func (UnimplementedTaskManagerServer) CreateQueue(context.Context, *CreateQueueRequest) (*Queue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateQueue not implemented")
+}
+func (UnimplementedTaskManagerServer) ListQueues(context.Context, *ListQueuesRequest) (*ListQueuesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListQueues not implemented")
+}
+func (UnimplementedTaskManagerServer) UpdateQueue(context.Context, *UpdateQueueRequest) (*Queue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateQueue not implemented")
+}
+func (UnimplementedTaskManagerServer) PauseQueue(context.Context, *QueueRequest) (*Queue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseQueue not implemented")
+}
+func (UnimplementedTaskManagerServer) ResumeQueue(context.Context, *QueueRequest) (*Queue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeQueue not implemented")
+}
+func (UnimplementedTaskManagerServer) DrainQueue(context.Context, *QueueRequest) (*Queue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DrainQueue not implemented")
+}
+func (UnimplementedTaskManagerServer) PauseProcessing(context.Context, *ProcessingRequest) (*ProcessingState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseProcessing not implemented")
+}
+func (UnimplementedTaskManagerServer) ResumeProcessing(context.Context, *ProcessingRequest) (*ProcessingState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeProcessing not implemented")
+}
+func (UnimplementedTaskManagerServer) CreateTenant(context.Context, *CreateTenantRequest) (*Tenant, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateTenant not implemented")
+}
+func (UnimplementedTaskManagerServer) ListTenants(context.Context, *ListTenantsRequest) (*ListTenantsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTenants not implemented")
+}
+func (UnimplementedTaskManagerServer) GetTenantUsage(context.Context, *TenantUsageRequest) (*TenantUsage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTenantUsage not implemented")
+}
+func (UnimplementedTaskManagerServer) QueryAuditLog(context.Context, *AuditLogRequest) (*AuditLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryAuditLog not implemented")
+}
 func (UnimplementedTaskManagerServer) mustEmbedUnimplementedTaskManagerServer() {}
 func (UnimplementedTaskManagerServer) testEmbeddedByValue()                     {}
 
@@ -194,9 +487,6 @@ func _TaskManager_StreamTaskStatus_Handler(srv interface{}, stream grpc.ServerSt
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type TaskManager_StreamTaskStatusServer = grpc.ServerStreamingServer[StatusResponse]
 
-// TaskManager_ServiceDesc is the grpc.ServiceDesc for TaskManager service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
 func _TaskManager_GetStatistics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(StatisticsRequest)
 	if err := dec(in); err != nil {
@@ -215,6 +505,272 @@ func _TaskManager_GetStatistics_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TaskManager_GetStatisticsHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatisticsHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).GetStatisticsHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_GetStatisticsHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).GetStatisticsHistory(ctx, req.(*StatisticsHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_BulkOperation_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BulkOperationRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TaskManagerServer).BulkOperation(m, &grpc.GenericServerStream[BulkOperationRequest, BulkOperationProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TaskManager_BulkOperationServer = grpc.ServerStreamingServer[BulkOperationProgress]
+
+func _TaskManager_UpdateTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).UpdateTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_UpdateTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).UpdateTask(ctx, req.(*UpdateTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_CreateQueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateQueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).CreateQueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_CreateQueue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).CreateQueue(ctx, req.(*CreateQueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_ListQueues_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListQueuesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).ListQueues(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_ListQueues_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).ListQueues(ctx, req.(*ListQueuesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_UpdateQueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateQueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).UpdateQueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_UpdateQueue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).UpdateQueue(ctx, req.(*UpdateQueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_PauseQueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).PauseQueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_PauseQueue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).PauseQueue(ctx, req.(*QueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_ResumeQueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).ResumeQueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_ResumeQueue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).ResumeQueue(ctx, req.(*QueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_DrainQueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).DrainQueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_DrainQueue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).DrainQueue(ctx, req.(*QueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_PauseProcessing_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProcessingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).PauseProcessing(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_PauseProcessing_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).PauseProcessing(ctx, req.(*ProcessingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_ResumeProcessing_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProcessingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).ResumeProcessing(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_ResumeProcessing_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).ResumeProcessing(ctx, req.(*ProcessingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_CreateTenant_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTenantRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).CreateTenant(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_CreateTenant_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).CreateTenant(ctx, req.(*CreateTenantRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_ListTenants_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTenantsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).ListTenants(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_ListTenants_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).ListTenants(ctx, req.(*ListTenantsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_GetTenantUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TenantUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).GetTenantUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_GetTenantUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).GetTenantUsage(ctx, req.(*TenantUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskManager_QueryAuditLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskManagerServer).QueryAuditLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskManager_QueryAuditLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskManagerServer).QueryAuditLog(ctx, req.(*AuditLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TaskManager_ServiceDesc is the grpc.ServiceDesc for TaskManager service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
 var TaskManager_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "taskmanager.TaskManager",
 	HandlerType: (*TaskManagerServer)(nil),
@@ -231,6 +787,62 @@ var TaskManager_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetStatistics",
 			Handler:    _TaskManager_GetStatistics_Handler,
 		},
+		{
+			MethodName: "GetStatisticsHistory",
+			Handler:    _TaskManager_GetStatisticsHistory_Handler,
+		},
+		{
+			MethodName: "UpdateTask",
+			Handler:    _TaskManager_UpdateTask_Handler,
+		},
+		{
+			MethodName: "CreateQueue",
+			Handler:    _TaskManager_CreateQueue_Handler,
+		},
+		{
+			MethodName: "ListQueues",
+			Handler:    _TaskManager_ListQueues_Handler,
+		},
+		{
+			MethodName: "UpdateQueue",
+			Handler:    _TaskManager_UpdateQueue_Handler,
+		},
+		{
+			MethodName: "PauseQueue",
+			Handler:    _TaskManager_PauseQueue_Handler,
+		},
+		{
+			MethodName: "ResumeQueue",
+			Handler:    _TaskManager_ResumeQueue_Handler,
+		},
+		{
+			MethodName: "DrainQueue",
+			Handler:    _TaskManager_DrainQueue_Handler,
+		},
+		{
+			MethodName: "PauseProcessing",
+			Handler:    _TaskManager_PauseProcessing_Handler,
+		},
+		{
+			MethodName: "ResumeProcessing",
+			Handler:    _TaskManager_ResumeProcessing_Handler,
+		},
+		{
+			MethodName: "CreateTenant",
+			Handler:    _TaskManager_CreateTenant_Handler,
+		},
+		{
+			MethodName: "ListTenants",
+			Handler:    _TaskManager_ListTenants_Handler,
+		},
+		{
+			MethodName: "GetTenantUsage",
+			Handler:    _TaskManager_GetTenantUsage_Handler,
+		},
+		{
+			MethodName: "QueryAuditLog",
+			Handler:    _TaskManager_QueryAuditLog_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -238,6 +850,11 @@ var TaskManager_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _TaskManager_StreamTaskStatus_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "BulkOperation",
+			Handler:       _TaskManager_BulkOperation_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "proto/taskmanager.proto",
 }
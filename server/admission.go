@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// heapCheckInterval is how often the heap size is read for admission control.
+// Checks only happen while tasks are submitted or the health is probed.
+const heapCheckInterval = time.Second
+
+// heapMetric is the runtime metric compared against the memory threshold.
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// admissionConfig sets when the server is overloaded and turns new tasks away.
+// Thresholds that are zero are not checked.
+type admissionConfig struct {
+	// MaxQueued is the number of QUEUED tasks across all tenants.
+	MaxQueued int `yaml:"max_queued"`
+	// MaxInFlight is the number of QUEUED and IN_PROGRESS tasks across all
+	// tenants. It bounds the processTask goroutines as well.
+	MaxInFlight int `yaml:"max_in_flight"`
+	// MaxMemoryMB is the size of the live heap in MiB.
+	MaxMemoryMB int `yaml:"max_memory_mb"`
+	// HighPriorityReserve is the share of every threshold kept for HIGH
+	// priority tasks. Other tasks are turned away once the load reaches
+	// the rest.
+	HighPriorityReserve float64 `yaml:"high_priority_reserve"`
+}
+
+// validate checks that the thresholds are usable.
+func (c admissionConfig) validate() error {
+	var errs []error
+	if c.MaxQueued < 0 || c.MaxInFlight < 0 || c.MaxMemoryMB < 0 {
+		errs = append(errs, errors.New("admission thresholds must not be negative"))
+	}
+	if c.HighPriorityReserve < 0 || c.HighPriorityReserve >= 1 {
+		errs = append(errs, errors.New("admission high_priority_reserve must be at least 0 and below 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// admissionControl tracks the load of the server against the admission
+// thresholds. It is guarded by the server lock.
+type admissionControl struct {
+	config admissionConfig
+	// heapBytes is the heap size read at heapChecked.
+	heapBytes   uint64
+	heapChecked time.Time
+	// rejected counts the tasks turned away, by priority.
+	rejected map[string]int64
+}
+
+// load returns the highest ratio of a load signal to its threshold, and the
+// name of that signal. The caller must hold s.mu.
+func (s *server) load(now time.Time) (float64, string) {
+	a := &s.admission
+	var queued, inProgress int
+	for _, tn := range s.tenants {
+		queued += tn.counts[statusQueued]
+		inProgress += tn.counts[statusInProgress]
+	}
+	if a.config.MaxMemoryMB > 0 && now.Sub(a.heapChecked) >= heapCheckInterval {
+		sample := []metrics.Sample{{Name: heapMetric}}
+		metrics.Read(sample)
+		a.heapBytes = sample[0].Value.Uint64()
+		a.heapChecked = now
+	}
+
+	ratio, signal := 0.0, ""
+	check := func(name string, value, threshold float64) {
+		if threshold > 0 && value/threshold > ratio {
+			ratio, signal = value/threshold, name
+		}
+	}
+	check("queued tasks", float64(queued), float64(a.config.MaxQueued))
+	check("in-flight tasks", float64(queued+inProgress), float64(a.config.MaxInFlight))
+	check("memory", float64(a.heapBytes), float64(a.config.MaxMemoryMB)*(1<<20))
+	return ratio, signal
+}
+
+// admit checks a new task of the given priority against the admission
+// thresholds. Tasks other than HIGH priority are turned away once the load
+// reaches the share of the thresholds not reserved for HIGH priority tasks.
+// The caller must hold s.mu.
+func (s *server) admit(priority string, now time.Time) error {
+	limit := 1.0
+	if priority != "HIGH" {
+		limit -= s.admission.config.HighPriorityReserve
+	}
+	ratio, signal := s.load(now)
+	if signal == "" || ratio < limit {
+		return nil
+	}
+	s.admission.rejected[priority]++
+	if priority != "HIGH" && ratio < 1 {
+		return status.Errorf(codes.Unavailable, "server is overloaded (%s), only HIGH priority tasks are accepted", signal)
+	}
+	return status.Errorf(codes.Unavailable, "server is overloaded (%s)", signal)
+}
+
+// overloaded reports whether the server turns away every new task, including
+// HIGH priority ones, and why. While only the reserve for HIGH priority tasks
+// is left, it is not overloaded but the reason says so.
+func (s *server) overloaded() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ratio, signal := s.load(time.Now())
+	if signal == "" || ratio < 1-s.admission.config.HighPriorityReserve {
+		return false, ""
+	}
+	reason := fmt.Sprintf("%s at %.0f%% of the threshold", signal, ratio*100)
+	if ratio < 1 {
+		return false, reason + ", only HIGH priority tasks are accepted"
+	}
+	return true, reason
+}
+
+// registerAdmissionMetrics reports the load of the server and the tasks turned
+// away by admission control through the meter.
+func (s *server) registerAdmissionMetrics(meter metric.Meter) error {
+	load, err := meter.Float64ObservableGauge("taskmanager.admission.load",
+		metric.WithDescription("Highest ratio of a load signal to its admission threshold."))
+	if err != nil {
+		return err
+	}
+	rejected, err := meter.Int64ObservableCounter("taskmanager.admission.rejected",
+		metric.WithDescription("Number of tasks turned away because the server was overloaded, by priority."))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		ratio, _ := s.load(time.Now())
+		o.ObserveFloat64(load, ratio)
+		for priority, n := range s.admission.rejected {
+			o.ObserveInt64(rejected, n, metric.WithAttributes(attribute.String("priority", priority)))
+		}
+		return nil
+	}, load, rejected)
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdmission(t *testing.T) {
+	s := newServer()
+	s.tenants[defaultTenant].queues[defaultQueue].paused = true
+	s.admission.config = admissionConfig{MaxQueued: 10, HighPriorityReserve: 0.2}
+
+	// The steps run in order, each submitting one task on top of the tasks
+	// accepted before.
+	steps := []struct {
+		name     string
+		priority string
+		want     codes.Code
+		// ready and reserved are the admission readiness afterwards: whether
+		// it is healthy and whether only the HIGH reserve is left.
+		ready, reserved bool
+	}{
+		{"below the threshold", "MEDIUM", codes.OK, true, false},
+		{"below the threshold", "LOW", codes.OK, true, false},
+		{"below the threshold", "MEDIUM", codes.OK, true, false},
+		{"below the threshold", "MEDIUM", codes.OK, true, false},
+		{"below the threshold", "HIGH", codes.OK, true, false},
+		{"below the threshold", "MEDIUM", codes.OK, true, false},
+		{"below the threshold", "MEDIUM", codes.OK, true, false},
+		{"last task outside the reserve", "LOW", codes.OK, true, true},
+		{"reserve turns LOW away", "LOW", codes.Unavailable, true, true},
+		{"reserve turns MEDIUM away", "MEDIUM", codes.Unavailable, true, true},
+		{"reserve admits HIGH", "HIGH", codes.OK, true, true},
+		{"reserve admits HIGH up to the threshold", "HIGH", codes.OK, false, false},
+		{"full threshold turns HIGH away", "HIGH", codes.Unavailable, false, false},
+	}
+	for i, tt := range steps {
+		_, err := s.SubmitTask(context.Background(), &pb.TaskRequest{TaskDescription: "test", Priority: tt.priority})
+		if status.Code(err) != tt.want {
+			t.Errorf("step %d, %s: SubmitTask returned %v, want %v", i, tt.name, err, tt.want)
+		}
+		got := s.readiness().Components["admission"]
+		if got.Healthy != tt.ready || strings.Contains(got.Detail, "only HIGH") != tt.reserved {
+			t.Errorf("step %d, %s: admission readiness %+v, want healthy %t and reserved %t", i, tt.name, got, tt.ready, tt.reserved)
+		}
+	}
+	if got := s.admission.rejected; got["LOW"] != 1 || got["MEDIUM"] != 1 || got["HIGH"] != 1 {
+		t.Errorf("rejected %v, want one task of each priority", got)
+	}
+
+	// Readiness comes back once the load drops below the threshold.
+	s.mu.Lock()
+	for _, task := range s.tasks {
+		if task.priority == "HIGH" {
+			s.dequeue(task)
+			s.setStatus(task, statusCancelled)
+		}
+	}
+	s.mu.Unlock()
+	if got := s.readiness().Components["admission"]; !got.Healthy || got.Detail != "" {
+		t.Errorf("admission readiness after the load dropped %+v, want healthy", got)
+	}
+}
+
+func TestAdmissionUnlimited(t *testing.T) {
+	s := newServer()
+	s.tenants[defaultTenant].queues[defaultQueue].paused = true
+	s.admission.config = admissionConfig{HighPriorityReserve: 0.5}
+	for range 50 {
+		if _, err := s.SubmitTask(context.Background(), &pb.TaskRequest{TaskDescription: "test", Priority: "LOW"}); err != nil {
+			t.Fatalf("SubmitTask without thresholds: %v", err)
+		}
+	}
+	if got := s.readiness().Components["admission"]; !got.Healthy || got.Detail != "" {
+		t.Errorf("admission readiness without thresholds %+v, want healthy", got)
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxAuditRequestLength is the length at which request summaries are cut off.
+const maxAuditRequestLength = 1024
+
+// Limits on the number of records returned by QueryAuditLog.
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+// auditedMethods lists the RPCs that change state and are written to the
+// audit log.
+var auditedMethods = map[string]bool{
+	pb.TaskManager_SubmitTask_FullMethodName:       true,
+	pb.TaskManager_BulkOperation_FullMethodName:    true,
+	pb.TaskManager_UpdateTask_FullMethodName:       true,
+	pb.TaskManager_CreateQueue_FullMethodName:      true,
+	pb.TaskManager_UpdateQueue_FullMethodName:      true,
+	pb.TaskManager_PauseQueue_FullMethodName:       true,
+	pb.TaskManager_ResumeQueue_FullMethodName:      true,
+	pb.TaskManager_DrainQueue_FullMethodName:       true,
+	pb.TaskManager_PauseProcessing_FullMethodName:  true,
+	pb.TaskManager_ResumeProcessing_FullMethodName: true,
+	pb.TaskManager_CreateTenant_FullMethodName:     true,
+}
+
+// auditConfig sets where the audit log is written.
+type auditConfig struct {
+	// File is the JSON lines file records are appended to; the audit log
+	// is off when it is empty.
+	File string `yaml:"file"`
+	// MaxSizeMB is the size in MiB at which the file is rotated.
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxBackups is the number of rotated files kept, named File.1 (the
+	// newest) to File.<MaxBackups>.
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// validate checks that the rotation settings are usable.
+func (c auditConfig) validate() error {
+	if c.MaxSizeMB <= 0 {
+		return errors.New("audit max_size_mb must be positive")
+	}
+	if c.MaxBackups < 0 {
+		return errors.New("audit max_backups must not be negative")
+	}
+	return nil
+}
+
+// auditRecord describes a call of a mutating RPC.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Tenant    string    `json:"tenant"`
+	Method    string    `json:"method"`
+	TaskID    string    `json:"task_id,omitempty"`
+	Request   string    `json:"request"`
+	Code      string    `json:"code"`
+}
+
+// proto converts the record for the API.
+func (r auditRecord) proto() *pb.AuditRecord {
+	return &pb.AuditRecord{
+		Time:      timestamppb.New(r.Time),
+		Principal: r.Principal,
+		Tenant:    r.Tenant,
+		Method:    r.Method,
+		TaskId:    r.TaskID,
+		Request:   r.Request,
+		Code:      r.Code,
+	}
+}
+
+// auditQuery selects audit records. Zero fields match every record.
+type auditQuery struct {
+	start, end time.Time
+	principal  string
+	method     string
+	tenant     string
+	limit      int
+}
+
+// matches reports whether the record is selected by the query.
+func (q auditQuery) matches(r auditRecord) bool {
+	if !q.start.IsZero() && r.Time.Before(q.start) {
+		return false
+	}
+	if !q.end.IsZero() && !r.Time.Before(q.end) {
+		return false
+	}
+	if q.principal != "" && r.Principal != q.principal {
+		return false
+	}
+	if q.method != "" && r.Method != q.method && !strings.HasSuffix(r.Method, "/"+q.method) {
+		return false
+	}
+	return q.tenant == "" || r.Tenant == q.tenant
+}
+
+// auditSink stores audit records and finds them again.
+type auditSink interface {
+	// write stores a record.
+	write(r auditRecord) error
+	// query returns the last records matching the query, oldest first.
+	query(q auditQuery) ([]auditRecord, error)
+	// close releases the sink.
+	close() error
+}
+
+// fileAuditSink writes audit records as JSON lines to a file that is rotated
+// once it reaches its maximum size.
+type fileAuditSink struct {
+	config auditConfig
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+}
+
+// newFileAuditSink opens the audit file for appending.
+func newFileAuditSink(config auditConfig) (*fileAuditSink, error) {
+	s := &fileAuditSink{config: config}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the current file. The caller must hold s.mu.
+func (s *fileAuditSink) open() error {
+	f, err := os.OpenFile(s.config.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Join(err, f.Close())
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+// backup returns the name of the nth rotated file.
+func (s *fileAuditSink) backup(n int) string {
+	return fmt.Sprintf("%s.%d", s.config.File, n)
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts a
+// new current file. The caller must hold s.mu.
+func (s *fileAuditSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	if s.config.MaxBackups == 0 {
+		if err := os.Remove(s.config.File); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return s.open()
+	}
+	for n := s.config.MaxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(s.backup(n), s.backup(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(s.config.File, s.backup(1)); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileAuditSink) write(r auditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size > 0 && s.size+int64(len(line)) > int64(s.config.MaxSizeMB)<<20 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// query reads the current file first, then the rotated files from the
+// newest one. It stops once it has found the limit of records or reached a
+// file holding records from before the start of the query, as older files
+// only hold earlier records. Lines that cannot be parsed are skipped.
+func (s *fileAuditSink) query(q auditQuery) ([]auditRecord, error) {
+	files, size, err := s.openFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var records []auditRecord
+	for i, f := range files {
+		var src io.Reader = f
+		if i == 0 {
+			src = io.LimitReader(f, size)
+		}
+		found, older, err := scanAuditRecords(src, q)
+		if err != nil {
+			return nil, err
+		}
+		records = append(found, records...)
+		if len(records) >= q.limit || older {
+			break
+		}
+	}
+	return records[max(len(records)-q.limit, 0):], nil
+}
+
+// scanAuditRecords returns the last records of the JSON lines matching the
+// query, up to its limit, and whether a record lies before the start of the
+// query.
+func scanAuditRecords(src io.Reader, q auditQuery) ([]auditRecord, bool, error) {
+	var records []auditRecord
+	older := false
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r auditRecord
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+		if !q.start.IsZero() && r.Time.Before(q.start) {
+			older = true
+		}
+		if !q.matches(r) {
+			continue
+		}
+		records = append(records, r)
+		if len(records) > q.limit {
+			records = records[1:]
+		}
+	}
+	return records, older, scanner.Err()
+}
+
+// openFiles opens the current file and the existing rotated files, newest
+// first, and returns the size of the current file. The files are read after
+// s.mu is released: open files stay readable when they are rotated, and
+// records written later lie beyond the returned size.
+func (s *fileAuditSink) openFiles() ([]*os.File, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []*os.File
+	for n := 0; n <= s.config.MaxBackups; n++ {
+		name := s.config.File
+		if n > 0 {
+			name = s.backup(n)
+		}
+		f, err := os.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, 0, err
+		}
+		files = append(files, f)
+	}
+	return files, s.size, nil
+}
+
+func (s *fileAuditSink) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// summarizeRequest returns the request in JSON form, cut off at
+// maxAuditRequestLength.
+func summarizeRequest(req any) string {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	// protojson varies its whitespace on purpose; compact it so records
+	// of equal requests are equal.
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err == nil {
+		b = buf.Bytes()
+	}
+	if len(b) > maxAuditRequestLength {
+		return string(b[:maxAuditRequestLength]) + "..."
+	}
+	return string(b)
+}
+
+// auditTaskID returns the ID of the task a call was about, taken from the
+// request or, for new tasks, the response.
+func auditTaskID(req, resp any) string {
+	if id := taskIDOf(req); id != "" {
+		return id
+	}
+	return taskIDOf(resp)
+}
+
+// recordAudit writes a record of a call to the audit log. Failed writes are
+// logged and do not fail the call.
+func (s *server) recordAudit(ctx context.Context, method string, req, resp any, err error) {
+	if s.audit == nil {
+		return
+	}
+	r := auditRecord{
+		Time:    time.Now().UTC(),
+		Tenant:  tenantName(ctx),
+		Method:  method,
+		TaskID:  auditTaskID(req, resp),
+		Request: summarizeRequest(req),
+		Code:    status.Code(err).String(),
+	}
+	if p, ok := principalFromContext(ctx); ok {
+		r.Principal = p.name
+	}
+	if err := s.audit.write(r); err != nil {
+		slog.ErrorContext(ctx, "failed to write audit record", "method", method, "error", err)
+	}
+}
+
+// auditUnaryInterceptor writes mutating unary RPCs to the audit log. It runs
+// after authentication, so calls rejected by authorization are recorded with
+// their principal.
+func (s *server) auditUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !auditedMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	resp, err := handler(ctx, req)
+	s.recordAudit(ctx, info.FullMethod, req, resp, err)
+	return resp, err
+}
+
+// auditStreamInterceptor writes mutating streaming RPCs to the audit log.
+func (s *server) auditStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !auditedMethods[info.FullMethod] {
+		return handler(srv, stream)
+	}
+	recorded := &requestStream{ServerStream: stream}
+	err := handler(srv, recorded)
+	s.recordAudit(stream.Context(), info.FullMethod, recorded.req, nil, err)
+	return err
+}
+
+// requestStream is a server stream that remembers the first message received,
+// the request of server streaming RPCs.
+type requestStream struct {
+	grpc.ServerStream
+	req any
+}
+
+func (s *requestStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.req == nil {
+		s.req = m
+	}
+	return err
+}
+
+// QueryAuditLog returns the most recent audit records matching the request.
+// Callers without the operator role only see the records of their tenant.
+func (s *server) QueryAuditLog(ctx context.Context, req *pb.AuditLogRequest) (*pb.AuditLogResponse, error) {
+	if s.audit == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the audit log is disabled")
+	}
+	q := auditQuery{
+		principal: req.GetPrincipal(),
+		method:    req.GetMethod(),
+		tenant:    req.GetTenant(),
+		limit:     int(req.GetLimit()),
+	}
+	if req.StartTime != nil {
+		q.start = req.StartTime.AsTime()
+	}
+	if req.EndTime != nil {
+		q.end = req.EndTime.AsTime()
+	}
+	switch {
+	case q.limit < 0:
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	case q.limit == 0:
+		q.limit = defaultAuditQueryLimit
+	case q.limit > maxAuditQueryLimit:
+		q.limit = maxAuditQueryLimit
+	}
+	if q.tenant != tenantName(ctx) {
+		if p, ok := principalFromContext(ctx); ok && !p.can(permTenants) {
+			if q.tenant != "" {
+				return nil, status.Errorf(codes.PermissionDenied, "%s may not read the audit log of other tenants", p.name)
+			}
+			q.tenant = p.tenant
+		}
+	}
+
+	records, err := s.audit.query(q)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read the audit log: %v", err)
+	}
+	res := &pb.AuditLogResponse{}
+	for _, r := range records {
+		res.Records = append(res.Records, r.proto())
+	}
+	return res, nil
+}
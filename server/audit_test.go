@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memoryAuditSink keeps audit records in memory.
+type memoryAuditSink struct {
+	mu      sync.Mutex
+	records []auditRecord
+}
+
+func (s *memoryAuditSink) write(r auditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *memoryAuditSink) query(q auditQuery) ([]auditRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []auditRecord
+	for _, r := range s.records {
+		if q.matches(r) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (s *memoryAuditSink) close() error { return nil }
+
+func TestFileAuditSinkQueryWhileRotating(t *testing.T) {
+	sink, err := newFileAuditSink(auditConfig{
+		File:       filepath.Join(t.TempDir(), "audit.jsonl"),
+		MaxSizeMB:  1,
+		MaxBackups: 2,
+	})
+	if err != nil {
+		t.Fatalf("newFileAuditSink: %v", err)
+	}
+	defer sink.close()
+
+	// About 4 MiB of records, so the files are rotated while they are read.
+	const records = 8000
+	padding := strings.Repeat("x", 500)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range records {
+			r := auditRecord{Time: time.Now(), Method: "/test/Write", TaskID: strconv.Itoa(i), Request: padding}
+			if err := sink.write(r); err != nil {
+				t.Errorf("write %d: %v", i, err)
+				return
+			}
+		}
+	}()
+
+	check := func() int {
+		got, err := sink.query(auditQuery{limit: maxAuditQueryLimit})
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		if len(got) > maxAuditQueryLimit {
+			t.Fatalf("query returned %d records, want at most %d", len(got), maxAuditQueryLimit)
+		}
+		// Every query sees a consistent snapshot: the last records
+		// written, oldest first, without gaps.
+		for i := 1; i < len(got); i++ {
+			prev, _ := strconv.Atoi(got[i-1].TaskID)
+			cur, _ := strconv.Atoi(got[i].TaskID)
+			if cur != prev+1 {
+				t.Fatalf("record %s follows record %s", got[i].TaskID, got[i-1].TaskID)
+			}
+		}
+		if len(got) == 0 {
+			return -1
+		}
+		last, _ := strconv.Atoi(got[len(got)-1].TaskID)
+		return last
+	}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			check()
+		}
+	}
+	if last := check(); last != records-1 {
+		t.Errorf("last record is %d, want %d", last, records-1)
+	}
+}
+
+func TestFileAuditSinkQueryStopsEarly(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	record := func(i int) auditRecord {
+		return auditRecord{Time: base.Add(time.Duration(i) * time.Minute), Method: "/test/Write", TaskID: strconv.Itoa(i)}
+	}
+	// Records 0 to 9 are in the second rotated file and 10 to 19 in the
+	// first one. The oldest rotated file cannot be read, so queries that
+	// reach it fail.
+	for n, from := range map[int]int{2: 0, 1: 10} {
+		var lines []byte
+		for i := from; i < from+10; i++ {
+			line, _ := json.Marshal(record(i))
+			lines = append(append(lines, line...), '\n')
+		}
+		if err := os.WriteFile(fmt.Sprintf("%s.%d", file, n), lines, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(file+".3", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := newFileAuditSink(auditConfig{File: file, MaxSizeMB: 1, MaxBackups: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.close()
+	for i := 20; i < 30; i++ {
+		if err := sink.write(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    auditQuery
+		// first and last are the task IDs of the records returned.
+		first, last int
+		wantErr     bool
+	}{
+		{"limit within the current file", auditQuery{limit: 5}, 25, 29, false},
+		{"limit within the first rotated file", auditQuery{limit: 15}, 15, 29, false},
+		{"limit within the last readable file", auditQuery{limit: 30}, 0, 29, false},
+		{"start within the first rotated file", auditQuery{start: base.Add(12 * time.Minute), limit: 100}, 12, 29, false},
+		{"end before the current file", auditQuery{end: base.Add(15 * time.Minute), limit: 3}, 12, 14, false},
+		{"every file", auditQuery{limit: 100}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := sink.query(tt.q)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: query read %d records, want the oldest file to be read", tt.name, len(got))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var ids []int
+		for _, r := range got {
+			id, _ := strconv.Atoi(r.TaskID)
+			ids = append(ids, id)
+		}
+		if len(ids) != tt.last-tt.first+1 || ids[0] != tt.first || ids[len(ids)-1] != tt.last {
+			t.Errorf("%s: query returned %v, want %d to %d", tt.name, ids, tt.first, tt.last)
+		}
+	}
+}
+
+func TestAuthFailuresAudited(t *testing.T) {
+	s := newServer()
+	sink := &memoryAuditSink{}
+	s.audit = sink
+	if err := s.configureAuth(authConfig{APIKeys: []apiKeyConfig{{Name: "ci", SHA256: hashKey("ci-secret"), Roles: []string{roleViewer}}}}); err != nil {
+		t.Fatal(err)
+	}
+	handler := func(ctx context.Context, req any) (any, error) { return &pb.StatusResponse{}, nil }
+	streamHandler := func(srv any, stream grpc.ServerStream) error { return nil }
+
+	// A read-only call is not audited once authenticated.
+	req := &pb.StatusRequest{TaskId: "42"}
+	info := &grpc.UnaryServerInfo{FullMethod: pb.TaskManager_CheckTaskStatus_FullMethodName}
+	if _, err := s.authUnaryInterceptor(incoming(apiKeyHeader, "ci-secret"), req, info, handler); err != nil {
+		t.Fatalf("call with a valid key: %v", err)
+	}
+	// Failed attempts are audited for every method.
+	if _, err := s.authUnaryInterceptor(incoming(apiKeyHeader, "guess"), req, info, handler); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("call with an invalid key returned %v, want %v", err, codes.Unauthenticated)
+	}
+	stream := &contextStream{ctx: incoming()}
+	streamInfo := &grpc.StreamServerInfo{FullMethod: pb.TaskManager_BulkOperation_FullMethodName}
+	if err := s.authStreamInterceptor(nil, stream, streamInfo, streamHandler); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("stream without credentials returned %v, want %v", err, codes.Unauthenticated)
+	}
+	// Public methods need no credentials and are not audited.
+	healthInfo := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	if _, err := s.authUnaryInterceptor(incoming(), nil, healthInfo, handler); err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+
+	want := []string{
+		fmt.Sprintf("%s %s %s", pb.TaskManager_CheckTaskStatus_FullMethodName, codes.Unauthenticated, "42"),
+		fmt.Sprintf("%s %s %s", pb.TaskManager_BulkOperation_FullMethodName, codes.Unauthenticated, ""),
+	}
+	if len(sink.records) != len(want) {
+		t.Fatalf("audit log has %d records, want %d: %+v", len(sink.records), len(want), sink.records)
+	}
+	for i, r := range sink.records {
+		if got := fmt.Sprintf("%s %s %s", r.Method, r.Code, r.TaskID); got != want[i] {
+			t.Errorf("record %d is %q, want %q", i, got, want[i])
+		}
+		if r.Principal != "" || r.Tenant != defaultTenant {
+			t.Errorf("record %d has principal %q and tenant %q, want none and %q", i, r.Principal, r.Tenant, defaultTenant)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/maciekb2/task-manager/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys carrying the caller's credentials.
+const (
+	apiKeyHeader        = "x-api-key"
+	authorizationHeader = "authorization"
+)
+
+// Ways a principal can authenticate.
+const (
+	authAPIKey = "api-key"
+	authJWT    = "jwt"
+)
+
+// principal identifies the authenticated caller of an RPC.
+type principal struct {
+	// name is the API key name or the token subject.
+	name string
+	// method is how the caller authenticated, authAPIKey or authJWT.
+	method string
+	// tenant is the tenant the caller acts in.
+	tenant string
+	// roles are the roles granted to the caller.
+	roles []string
+}
+
+type principalKey struct{}
+
+// withPrincipal returns a context carrying the principal.
+func withPrincipal(ctx context.Context, p *principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFromContext returns the principal of the RPC, if the caller was
+// authenticated.
+func principalFromContext(ctx context.Context) (*principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*principal)
+	return p, ok
+}
+
+// callerName returns the name of the caller, empty while authentication is
+// off.
+func callerName(ctx context.Context) string {
+	if p, ok := principalFromContext(ctx); ok {
+		return p.name
+	}
+	return ""
+}
+
+// authConfig configures how callers authenticate. Authentication is off when
+// neither API keys nor a JWKS file are configured.
+type authConfig struct {
+	APIKeys []apiKeyConfig `yaml:"api_keys"`
+	// JWKSFile holds the keys bearer tokens are verified against.
+	JWKSFile string `yaml:"jwks_file"`
+	// Issuer and Audience, when set, must match the token claims.
+	Issuer   string `yaml:"jwt_issuer"`
+	Audience string `yaml:"jwt_audience"`
+	// DefaultRoles are granted to every authenticated caller, in addition
+	// to the roles of its key or token.
+	DefaultRoles []string `yaml:"default_roles"`
+}
+
+// apiKeyConfig is a static API key. Only the hash of the key is configured.
+type apiKeyConfig struct {
+	// Name identifies the holder of the key.
+	Name string `yaml:"name"`
+	// SHA256 is the hex encoded SHA-256 hash of the key.
+	SHA256 string `yaml:"sha256"`
+	// Tenant is the tenant of the holder, the default tenant when empty.
+	Tenant string `yaml:"tenant"`
+	// Roles are the roles granted to the holder of the key.
+	Roles []string `yaml:"roles"`
+}
+
+// enabled reports whether callers must authenticate.
+func (c authConfig) enabled() bool {
+	return len(c.APIKeys) > 0 || c.JWKSFile != ""
+}
+
+// authenticator checks the credentials sent with an RPC.
+type authenticator struct {
+	// apiKeys maps the hash of each key to its holder.
+	apiKeys map[[sha256.Size]byte]apiKeyConfig
+	// jwtKeys maps key IDs to the public keys of the JWKS file.
+	jwtKeys map[string]any
+	parser  *jwt.Parser
+	// defaultRoles are granted to every principal.
+	defaultRoles []string
+}
+
+// newAuthenticator builds an authenticator from the configuration, loading
+// the JWKS file if one is set.
+func newAuthenticator(cfg authConfig) (*authenticator, error) {
+	if err := validateRoles(cfg.DefaultRoles); err != nil {
+		return nil, fmt.Errorf("default_roles: %w", err)
+	}
+	a := &authenticator{
+		apiKeys:      make(map[[sha256.Size]byte]apiKeyConfig),
+		defaultRoles: cfg.DefaultRoles,
+	}
+	for _, key := range cfg.APIKeys {
+		sum, err := hex.DecodeString(key.SHA256)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("API key %q: sha256 must be a hex encoded SHA-256 hash", key.Name)
+		}
+		if key.Name == "" {
+			return nil, errors.New("API key without a name")
+		}
+		if err := validateRoles(key.Roles); err != nil {
+			return nil, fmt.Errorf("API key %q: %w", key.Name, err)
+		}
+		a.apiKeys[[sha256.Size]byte(sum)] = key
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwtKeys = keys
+		opts := []jwt.ParserOption{
+			jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+			jwt.WithExpirationRequired(),
+		}
+		if cfg.Issuer != "" {
+			opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+		}
+		if cfg.Audience != "" {
+			opts = append(opts, jwt.WithAudience(cfg.Audience))
+		}
+		a.parser = jwt.NewParser(opts...)
+	}
+	return a, nil
+}
+
+// authenticate identifies the caller from the x-api-key or the
+// authorization bearer token metadata of the RPC.
+func (a *authenticator) authenticate(ctx context.Context) (*principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if keys := md.Get(apiKeyHeader); len(keys) > 0 {
+		key, ok := a.apiKeys[sha256.Sum256([]byte(keys[0]))]
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		return a.principal(key.Name, authAPIKey, key.Tenant, key.Roles), nil
+	}
+
+	if values := md.Get(authorizationHeader); len(values) > 0 {
+		token, ok := strings.CutPrefix(values[0], "Bearer ")
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+		}
+		if a.parser == nil {
+			return nil, status.Error(codes.Unauthenticated, "bearer tokens are not accepted")
+		}
+		claims := &tokenClaims{}
+		if _, err := a.parser.ParseWithClaims(token, claims, a.jwtKey); err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+		}
+		if claims.Subject == "" {
+			return nil, status.Error(codes.Unauthenticated, "invalid token: no subject")
+		}
+		// Roles unknown to the server are ignored, the issuer may use them
+		// for other services.
+		var roles []string
+		for _, role := range claims.Roles {
+			if _, ok := rolePermissions[role]; ok {
+				roles = append(roles, role)
+			}
+		}
+		return a.principal(claims.Subject, authJWT, claims.Tenant, roles), nil
+	}
+
+	return nil, status.Error(codes.Unauthenticated, "missing credentials")
+}
+
+// principal returns a principal of the tenant holding the given and the
+// default roles.
+func (a *authenticator) principal(name, method, tenant string, roles []string) *principal {
+	if tenant == "" {
+		tenant = defaultTenant
+	}
+	return &principal{
+		name:   name,
+		method: method,
+		tenant: tenant,
+		roles:  append(slices.Clone(a.defaultRoles), roles...),
+	}
+}
+
+// tokenClaims are the claims read from bearer tokens.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	// Tenant is the tenant of the subject, the default tenant when empty.
+	Tenant string `json:"tenant"`
+	// Roles are the roles granted to the subject.
+	Roles []string `json:"roles"`
+}
+
+// jwtKey returns the key a token was signed with, chosen by its key ID. A
+// token without a key ID is accepted when the JWKS holds a single key.
+func (a *authenticator) jwtKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(a.jwtKeys) == 1 {
+		for _, key := range a.jwtKeys {
+			return key, nil
+		}
+	}
+	key, ok := a.jwtKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// jsonWebKey is a public key in a JWKS document (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS reads the signing keys of a JWKS file, indexed by key ID.
+func loadJWKS(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := make(map[string]any)
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no signing keys", path)
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK to an RSA, ECDSA or Ed25519 public key.
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if _, err := key.ECDH(); err != nil {
+			return nil, err
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// configureAuth replaces the authenticator with one built from the
+// configuration, or turns authentication off when nothing is configured.
+func (s *server) configureAuth(cfg authConfig) error {
+	if !cfg.enabled() {
+		s.authn.Store(nil)
+		return nil
+	}
+	a, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	s.authn.Store(a)
+	return nil
+}
+
+// authenticate attaches the caller's principal to the context. Calls are
+// let through unchanged while authentication is off.
+func (s *server) authenticate(ctx context.Context) (context.Context, error) {
+	a := s.authn.Load()
+	if a == nil {
+		return ctx, nil
+	}
+	p, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logging.With(ctx, slog.String("principal", p.name))
+	return withPrincipal(ctx, p), nil
+}
+
+// publicMethods lists the RPCs that are served without credentials.
+var publicMethods = map[string]bool{
+	healthpb.Health_Check_FullMethodName: true,
+	healthpb.Health_List_FullMethodName:  true,
+	healthpb.Health_Watch_FullMethodName: true,
+}
+
+// authUnaryInterceptor authenticates unary RPCs. Failed attempts are
+// written to the audit log whatever the method.
+func (s *server) authUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	authCtx, err := s.authenticate(ctx)
+	if err != nil {
+		s.recordAudit(ctx, info.FullMethod, req, nil, err)
+		return nil, err
+	}
+	return handler(authCtx, req)
+}
+
+// authStreamInterceptor authenticates streaming RPCs. Failed attempts are
+// written to the audit log whatever the method.
+func (s *server) authStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if publicMethods[info.FullMethod] {
+		return handler(srv, stream)
+	}
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		s.recordAudit(stream.Context(), info.FullMethod, nil, nil, err)
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+// contextStream is a server stream with a replaced context.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
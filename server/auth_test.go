@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "task-manager"
+)
+
+// testKeys are the private keys of the JWKS used by the tests.
+type testKeys struct {
+	ec  *ecdsa.PrivateKey
+	rsa *rsa.PrivateKey
+	ed  ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{ec: ec, rsa: rk, ed: ed}
+}
+
+// writeJWKS writes the public keys as a JWKS file and returns its path.
+func (k testKeys) writeJWKS(t *testing.T) string {
+	t.Helper()
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	doc := map[string][]jsonWebKey{"keys": {
+		{Kty: "EC", Kid: "ec", Use: "sig", Crv: "P-256", X: enc(k.ec.X.Bytes()), Y: enc(k.ec.Y.Bytes())},
+		{Kty: "RSA", Kid: "rsa", N: enc(k.rsa.N.Bytes()), E: enc(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: enc(k.ed.Public().(ed25519.PublicKey))},
+		// Encryption keys are skipped.
+		{Kty: "RSA", Kid: "enc", Use: "enc", N: enc(k.rsa.N.Bytes()), E: enc(big.NewInt(int64(k.rsa.E)).Bytes())},
+	}}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// claims returns valid claims for the subject.
+func claims(subject string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": subject,
+		"iss": testIssuer,
+		"aud": testAudience,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// sign returns a token with the claims signed by key with the method. A
+// non-empty kid is set in the header.
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, c jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// incoming returns a context carrying the metadata of an incoming RPC.
+func incoming(kv ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	keys := newTestKeys(t)
+	a, err := newAuthenticator(authConfig{
+		JWKSFile:     keys.writeJWKS(t),
+		Issuer:       testIssuer,
+		Audience:     testAudience,
+		DefaultRoles: []string{roleViewer},
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(changes jwt.MapClaims) jwt.MapClaims {
+		c := claims("alice")
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		token string
+		// want is the principal, nil when the token must be rejected.
+		want *principal
+	}{{
+		name:  "ES256",
+		token: sign(t, jwt.SigningMethodES256, "ec", keys.ec, with(jwt.MapClaims{"tenant": "acme", "roles": []string{roleSubmitter, "billing"}})),
+		want:  &principal{name: "alice", method: authJWT, tenant: "acme", roles: []string{roleViewer, roleSubmitter}},
+	}, {
+		name:  "RS256",
+		token: sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, claims("alice")),
+		want:  &principal{name: "alice", method: authJWT, tenant: defaultTenant, roles: []string{roleViewer}},
+	}, {
+		name:  "PS256",
+		token: sign(t, jwt.SigningMethodPS256, "rsa", keys.rsa, claims("alice")),
+		want:  &principal{name: "alice", method: authJWT, tenant: defaultTenant, roles: []string{roleViewer}},
+	}, {
+		name:  "EdDSA",
+		token: sign(t, jwt.SigningMethodEdDSA, "ed", keys.ed, claims("alice")),
+		want:  &principal{name: "alice", method: authJWT, tenant: defaultTenant, roles: []string{roleViewer}},
+	}, {
+		name:  "expired",
+		token: sign(t, jwt.SigningMethodES256, "ec", keys.ec, with(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})),
+	}, {
+		name:  "not yet valid",
+		token: sign(t, jwt.SigningMethodES256, "ec", keys.ec, with(jwt.MapClaims{"nbf": time.Now().Add(time.Hour).Unix()})),
+	}, {
+		name:  "no expiry",
+		token: sign(t, jwt.SigningMethodES256, "ec", keys.ec, with(jwt.MapClaims{"exp": nil})),
+	}, {
+		name:  "unsigned",
+		token: sign(t, jwt.SigningMethodNone, "ec", jwt.UnsafeAllowNoneSignatureType, claims("alice")),
+	}, {
+		// An HMAC token keyed with the public key must not pass as signed
+		// by the key holder.
+		name:  "HS256",
+		token: sign(t, jwt.SigningMethodHS256, "rsa", keys.rsa.PublicKey.N.Bytes(), claims("alice")),
+	}, {
+		name:  "unknown kid",
+		token: sign(t, jwt.SigningMethodES256, "rotated", keys.ec, claims("alice")),
+	}, {
+		name:  "enc kid",
+		token: sign(t, jwt.SigningMethodRS256, "enc", keys.rsa, claims("alice")),
+	}, {
+		// Several keys are configured, so the key must be named.
+		name:  "no kid",
+		token: sign(t, jwt.SigningMethodES256, "", keys.ec, claims("alice")),
+	}, {
+		name:  "wrong key",
+		token: sign(t, jwt.SigningMethodES256, "ec", other, claims("alice")),
+	}, {
+		name:  "key of another type",
+		token: sign(t, jwt.SigningMethodES256, "rsa", keys.ec, claims("alice")),
+	}, {
+		name:  "wrong issuer",
+		token: sign(t, jwt.SigningMethodES256, "ec", keys.ec, with(jwt.MapClaims{"iss": "https://evil.example.com"})),
+	}, {
+		name:  "wrong audience",
+		token: sign(t, jwt.SigningMethodES256, "ec", keys.ec, with(jwt.MapClaims{"aud": "other-service"})),
+	}, {
+		name:  "no subject",
+		token: sign(t, jwt.SigningMethodES256, "ec", keys.ec, with(jwt.MapClaims{"sub": nil})),
+	}, {
+		name:  "malformed",
+		token: "not.a.token",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.authenticate(incoming(authorizationHeader, "Bearer "+tt.token))
+			if tt.want == nil {
+				if status.Code(err) != codes.Unauthenticated {
+					t.Fatalf("authenticate returned %v, %v, want %v", p, err, codes.Unauthenticated)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("authenticate: %v", err)
+			}
+			if p.name != tt.want.name || p.method != tt.want.method || p.tenant != tt.want.tenant || !slices.Equal(p.roles, tt.want.roles) {
+				t.Errorf("authenticate = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSingleKeyWithoutKid(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	data, _ := json.Marshal(map[string][]jsonWebKey{"keys": {
+		{Kty: "EC", Kid: "only", Crv: "P-256", X: enc(key.X.Bytes()), Y: enc(key.Y.Bytes())},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := newAuthenticator(authConfig{JWKSFile: path})
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+
+	token := sign(t, jwt.SigningMethodES256, "", key, jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	p, err := a.authenticate(incoming(authorizationHeader, "Bearer "+token))
+	if err != nil || p.name != "bob" {
+		t.Errorf("authenticate = %v, %v, want principal bob", p, err)
+	}
+}
+
+func TestLoadJWKSErrors(t *testing.T) {
+	for name, doc := range map[string]string{
+		"no keys":         `{"keys": []}`,
+		"only enc keys":   `{"keys": [{"kty": "RSA", "kid": "a", "use": "enc", "n": "AQAB", "e": "AQAB"}]}`,
+		"unknown kty":     `{"keys": [{"kty": "oct", "kid": "a", "k": "c2VjcmV0"}]}`,
+		"unknown curve":   `{"keys": [{"kty": "EC", "kid": "a", "crv": "P-192", "x": "AQAB", "y": "AQAB"}]}`,
+		"point off curve": `{"keys": [{"kty": "EC", "kid": "a", "crv": "P-256", "x": "AQAB", "y": "AQAB"}]}`,
+		"short Ed25519":   `{"keys": [{"kty": "OKP", "kid": "a", "crv": "Ed25519", "x": "AQAB"}]}`,
+		"not JSON":        `keys: []`,
+	} {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadJWKS(path); err == nil {
+			t.Errorf("%s: loadJWKS succeeded, want an error", name)
+		}
+	}
+}
+
+// hashKey returns the configured form of an API key.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	a, err := newAuthenticator(authConfig{
+		APIKeys: []apiKeyConfig{
+			{Name: "ci", SHA256: hashKey("ci-secret"), Roles: []string{roleSubmitter}},
+			{Name: "acme-admin", SHA256: hashKey("acme-secret"), Tenant: "acme", Roles: []string{roleAdmin}},
+		},
+		DefaultRoles: []string{roleViewer},
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		md   []string
+		want *principal
+		code codes.Code
+	}{{
+		name: "default tenant",
+		md:   []string{apiKeyHeader, "ci-secret"},
+		want: &principal{name: "ci", method: authAPIKey, tenant: defaultTenant, roles: []string{roleViewer, roleSubmitter}},
+	}, {
+		name: "tenant of the key",
+		md:   []string{apiKeyHeader, "acme-secret"},
+		want: &principal{name: "acme-admin", method: authAPIKey, tenant: "acme", roles: []string{roleViewer, roleAdmin}},
+	}, {
+		// The hash itself is not a key.
+		name: "hash as key",
+		md:   []string{apiKeyHeader, hashKey("ci-secret")},
+		code: codes.Unauthenticated,
+	}, {
+		name: "unknown key",
+		md:   []string{apiKeyHeader, "guess"},
+		code: codes.Unauthenticated,
+	}, {
+		name: "bearer tokens not accepted",
+		md:   []string{authorizationHeader, "Bearer ci-secret"},
+		code: codes.Unauthenticated,
+	}, {
+		name: "not a bearer token",
+		md:   []string{authorizationHeader, "Basic Y2k6Y2ktc2VjcmV0"},
+		code: codes.Unauthenticated,
+	}, {
+		name: "missing credentials",
+		code: codes.Unauthenticated,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.authenticate(incoming(tt.md...))
+			if tt.want == nil {
+				if status.Code(err) != tt.code {
+					t.Fatalf("authenticate returned %v, %v, want %v", p, err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("authenticate: %v", err)
+			}
+			if p.name != tt.want.name || p.method != tt.want.method || p.tenant != tt.want.tenant || !slices.Equal(p.roles, tt.want.roles) {
+				t.Errorf("authenticate = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticatorErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  authConfig
+	}{
+		{"hash not hex", authConfig{APIKeys: []apiKeyConfig{{Name: "ci", SHA256: "ci-secret"}}}},
+		{"hash too short", authConfig{APIKeys: []apiKeyConfig{{Name: "ci", SHA256: hashKey("ci-secret")[:32]}}}},
+		{"key without name", authConfig{APIKeys: []apiKeyConfig{{SHA256: hashKey("ci-secret")}}}},
+		{"unknown key role", authConfig{APIKeys: []apiKeyConfig{{Name: "ci", SHA256: hashKey("ci-secret"), Roles: []string{"root"}}}}},
+		{"unknown default role", authConfig{APIKeys: []apiKeyConfig{{Name: "ci", SHA256: hashKey("ci-secret")}}, DefaultRoles: []string{"root"}}},
+		{"missing JWKS file", authConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.json")}},
+	}
+	for _, tt := range tests {
+		if _, err := newAuthenticator(tt.cfg); err == nil {
+			t.Errorf("%s: newAuthenticator succeeded, want an error", tt.name)
+		}
+	}
+}
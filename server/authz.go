@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionalphapb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// Roles a principal can be granted.
+const (
+	// roleViewer may read the status of its own tasks and the statistics.
+	roleViewer = "viewer"
+	// roleSubmitter may also submit tasks and update its own tasks.
+	roleSubmitter = "submitter"
+	// roleAuditor may read the tasks of every principal of its tenant.
+	roleAuditor = "auditor"
+	// roleAdmin may do everything within its tenant, including bulk
+	// operations and managing queues.
+	roleAdmin = "admin"
+	// roleOperator is an admin that may also manage tenants and pause the
+	// whole server.
+	roleOperator = "operator"
+)
+
+// permission is a group of actions the policy grants or denies.
+type permission int
+
+const (
+	// permView allows reading own tasks, statistics and queues.
+	permView permission = iota
+	// permSubmit allows submitting and updating own tasks.
+	permSubmit
+	// permViewAll lifts the ownership restriction on reading tasks.
+	permViewAll
+	// permAdmin allows cancelling and purging tasks in bulk, changing any
+	// task and managing queues and processing.
+	permAdmin
+	// permTenants allows managing tenants and whole-server processing.
+	permTenants
+)
+
+// rolePermissions lists what each role is allowed to do.
+var rolePermissions = map[string][]permission{
+	roleViewer:    {permView},
+	roleSubmitter: {permView, permSubmit},
+	roleAuditor:   {permView, permViewAll},
+	roleAdmin:     {permView, permSubmit, permViewAll, permAdmin},
+	roleOperator:  {permView, permSubmit, permViewAll, permAdmin, permTenants},
+}
+
+// methodPermissions maps every RPC to the permission needed to call it.
+// RPCs missing from the map are denied.
+var methodPermissions = map[string]permission{
+	pb.TaskManager_SubmitTask_FullMethodName:           permSubmit,
+	pb.TaskManager_CheckTaskStatus_FullMethodName:      permView,
+	pb.TaskManager_StreamTaskStatus_FullMethodName:     permView,
+	pb.TaskManager_GetStatistics_FullMethodName:        permView,
+	pb.TaskManager_GetStatisticsHistory_FullMethodName: permView,
+	pb.TaskManager_BulkOperation_FullMethodName:        permAdmin,
+	pb.TaskManager_UpdateTask_FullMethodName:           permSubmit,
+	pb.TaskManager_CreateQueue_FullMethodName:          permAdmin,
+	pb.TaskManager_ListQueues_FullMethodName:           permView,
+	pb.TaskManager_UpdateQueue_FullMethodName:          permAdmin,
+	pb.TaskManager_PauseQueue_FullMethodName:           permAdmin,
+	pb.TaskManager_ResumeQueue_FullMethodName:          permAdmin,
+	pb.TaskManager_DrainQueue_FullMethodName:           permAdmin,
+	pb.TaskManager_PauseProcessing_FullMethodName:      permAdmin,
+	pb.TaskManager_ResumeProcessing_FullMethodName:     permAdmin,
+	pb.TaskManager_CreateTenant_FullMethodName:         permTenants,
+	pb.TaskManager_ListTenants_FullMethodName:          permTenants,
+	pb.TaskManager_GetTenantUsage_FullMethodName:       permAdmin,
+	pb.TaskManager_QueryAuditLog_FullMethodName:        permAdmin,
+	// Server reflection, when enabled, describes the API to any caller
+	// that may read it.
+	reflectionpb.ServerReflection_ServerReflectionInfo_FullMethodName:      permView,
+	reflectionalphapb.ServerReflection_ServerReflectionInfo_FullMethodName: permView,
+}
+
+// validateRoles checks that every role is known.
+func validateRoles(roles []string) error {
+	for _, role := range roles {
+		if _, ok := rolePermissions[role]; !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// can reports whether one of the principal's roles grants the permission.
+func (p *principal) can(perm permission) bool {
+	for _, role := range p.roles {
+		if slices.Contains(rolePermissions[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks that the principal of the RPC may call the method. Calls
+// without a principal are only made while authentication is off and are
+// always allowed.
+func authorize(ctx context.Context, method string) error {
+	p, ok := principalFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	perm, known := methodPermissions[method]
+	if !known || !p.can(perm) {
+		return status.Errorf(codes.PermissionDenied, "%s may not call %s", p.name, method)
+	}
+	return nil
+}
+
+// authorizeUnaryInterceptor authorizes unary RPCs. It runs after
+// authentication.
+func authorizeUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authorizeStreamInterceptor authorizes streaming RPCs. It runs after
+// authentication.
+func authorizeStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// checkPermission returns a PermissionDenied error unless the caller has the
+// permission needed for the action. Calls without a principal are always
+// allowed.
+func checkPermission(ctx context.Context, perm permission, action string) error {
+	if p, ok := principalFromContext(ctx); ok && !p.can(perm) {
+		return status.Errorf(codes.PermissionDenied, "%s may not %s", p.name, action)
+	}
+	return nil
+}
+
+// canSee reports whether the caller may read the task. Only tasks of the
+// caller's tenant are visible, and of those the ones it submitted unless one
+// of its roles lets it see all tasks.
+func canSee(ctx context.Context, t *task) bool {
+	if t.tenant != tenantName(ctx) {
+		return false
+	}
+	p, ok := principalFromContext(ctx)
+	return !ok || p.can(permViewAll) || t.owner == p.name
+}
+
+// canChange reports whether the caller may update the task. Principals may
+// change the tasks they submitted; admins may change any task of their
+// tenant.
+func canChange(ctx context.Context, t *task) bool {
+	if t.tenant != tenantName(ctx) {
+		return false
+	}
+	p, ok := principalFromContext(ctx)
+	return !ok || p.can(permAdmin) || t.owner == p.name
+}
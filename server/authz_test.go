@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// as returns a context of a call made by a principal of the tenant with the
+// roles.
+func as(name, tenant string, roles ...string) context.Context {
+	return withPrincipal(context.Background(), &principal{name: name, method: authAPIKey, tenant: tenant, roles: roles})
+}
+
+func TestMethodPermissionsCoverAPI(t *testing.T) {
+	for _, m := range pb.TaskManager_ServiceDesc.Methods {
+		if _, ok := methodPermissions["/"+pb.TaskManager_ServiceDesc.ServiceName+"/"+m.MethodName]; !ok {
+			t.Errorf("%s has no permission, so every caller is denied", m.MethodName)
+		}
+	}
+	for _, s := range pb.TaskManager_ServiceDesc.Streams {
+		if _, ok := methodPermissions["/"+pb.TaskManager_ServiceDesc.ServiceName+"/"+s.StreamName]; !ok {
+			t.Errorf("%s has no permission, so every caller is denied", s.StreamName)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	const unknownMethod = "/taskmanager.TaskManager/DropDatabase"
+	tests := []struct {
+		method string
+		// allowed lists the roles that may call the method.
+		allowed []string
+	}{
+		{pb.TaskManager_CheckTaskStatus_FullMethodName, []string{roleViewer, roleSubmitter, roleAuditor, roleAdmin, roleOperator}},
+		{pb.TaskManager_StreamTaskStatus_FullMethodName, []string{roleViewer, roleSubmitter, roleAuditor, roleAdmin, roleOperator}},
+		{pb.TaskManager_GetStatistics_FullMethodName, []string{roleViewer, roleSubmitter, roleAuditor, roleAdmin, roleOperator}},
+		{pb.TaskManager_ListQueues_FullMethodName, []string{roleViewer, roleSubmitter, roleAuditor, roleAdmin, roleOperator}},
+		{pb.TaskManager_SubmitTask_FullMethodName, []string{roleSubmitter, roleAdmin, roleOperator}},
+		{pb.TaskManager_UpdateTask_FullMethodName, []string{roleSubmitter, roleAdmin, roleOperator}},
+		{pb.TaskManager_BulkOperation_FullMethodName, []string{roleAdmin, roleOperator}},
+		{pb.TaskManager_CreateQueue_FullMethodName, []string{roleAdmin, roleOperator}},
+		{pb.TaskManager_PauseProcessing_FullMethodName, []string{roleAdmin, roleOperator}},
+		{pb.TaskManager_QueryAuditLog_FullMethodName, []string{roleAdmin, roleOperator}},
+		{pb.TaskManager_CreateTenant_FullMethodName, []string{roleOperator}},
+		{pb.TaskManager_ListTenants_FullMethodName, []string{roleOperator}},
+		{unknownMethod, nil},
+	}
+	roles := []string{roleViewer, roleSubmitter, roleAuditor, roleAdmin, roleOperator}
+	for _, tt := range tests {
+		for _, role := range roles {
+			want := codes.PermissionDenied
+			for _, r := range tt.allowed {
+				if r == role {
+					want = codes.OK
+				}
+			}
+			err := authorize(as("alice", defaultTenant, role), tt.method)
+			if status.Code(err) != want {
+				t.Errorf("%s calling %s: got %v, want %v", role, tt.method, err, want)
+			}
+		}
+	}
+
+	// Principals without roles may call nothing.
+	if err := authorize(as("nobody", defaultTenant), pb.TaskManager_CheckTaskStatus_FullMethodName); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("principal without roles: got %v, want %v", err, codes.PermissionDenied)
+	}
+	// Without authentication there is no principal and every call is let
+	// through, including unknown methods.
+	if err := authorize(context.Background(), unknownMethod); err != nil {
+		t.Errorf("call without a principal: got %v, want nil", err)
+	}
+}
+
+func TestCheckPermission(t *testing.T) {
+	tests := []struct {
+		ctx  context.Context
+		perm permission
+		want codes.Code
+	}{
+		{context.Background(), permTenants, codes.OK},
+		{as("alice", defaultTenant, roleAdmin), permTenants, codes.PermissionDenied},
+		{as("alice", defaultTenant, roleOperator), permTenants, codes.OK},
+		{as("alice", defaultTenant, roleViewer, roleAuditor), permViewAll, codes.OK},
+		{as("alice", defaultTenant, roleSubmitter), permViewAll, codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		if err := checkPermission(tt.ctx, tt.perm, "test"); status.Code(err) != tt.want {
+			t.Errorf("checkPermission(%v, %d) = %v, want %v", tt.ctx.Value(principalKey{}), tt.perm, err, tt.want)
+		}
+	}
+}
+
+func TestTaskVisibility(t *testing.T) {
+	own := &task{id: "1", tenant: defaultTenant, owner: "alice"}
+	others := &task{id: "2", tenant: defaultTenant, owner: "bob"}
+	unowned := &task{id: "3", tenant: defaultTenant}
+	acme := &task{id: "4", tenant: "acme", owner: "alice"}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		task *task
+		see  bool
+		// change is whether the caller may change the task.
+		change bool
+	}{
+		{"own task", as("alice", defaultTenant, roleSubmitter), own, true, true},
+		{"task of another principal", as("alice", defaultTenant, roleSubmitter), others, false, false},
+		{"task submitted without auth", as("alice", defaultTenant, roleSubmitter), unowned, false, false},
+		{"auditor reads others", as("carol", defaultTenant, roleAuditor), others, true, false},
+		{"admin changes others", as("carol", defaultTenant, roleAdmin), others, true, true},
+		{"operator changes others", as("carol", defaultTenant, roleOperator), others, true, true},
+		// Tenants are isolated whatever the roles or the name.
+		{"same name in another tenant", as("alice", defaultTenant, roleSubmitter), acme, false, false},
+		{"admin of another tenant", as("carol", defaultTenant, roleAdmin), acme, false, false},
+		{"operator of another tenant", as("carol", "globex", roleOperator), acme, false, false},
+		{"principal of the tenant", as("alice", "acme", roleSubmitter), acme, true, true},
+		{"principal of the tenant, default task", as("alice", "acme", roleAdmin), own, false, false},
+		// Without authentication every task of the default tenant is
+		// visible, and only those.
+		{"no auth", context.Background(), others, true, true},
+		{"no auth, other tenant", context.Background(), acme, false, false},
+	}
+	for _, tt := range tests {
+		if got := canSee(tt.ctx, tt.task); got != tt.see {
+			t.Errorf("%s: canSee = %t, want %t", tt.name, got, tt.see)
+		}
+		if got := canChange(tt.ctx, tt.task); got != tt.change {
+			t.Errorf("%s: canChange = %t, want %t", tt.name, got, tt.change)
+		}
+	}
+}
+
+func TestCheckTaskStatusHidesOtherTasks(t *testing.T) {
+	s := newServer()
+	s.tenants["acme"] = newTenant("acme", tenantConfig{})
+
+	res, err := s.SubmitTask(as("alice", "acme", roleSubmitter), &pb.TaskRequest{TaskDescription: "test"})
+	if err != nil {
+		t.Fatalf("SubmitTask: %v", err)
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"owner", as("alice", "acme", roleSubmitter), statusInProgress},
+		{"other principal", as("bob", "acme", roleSubmitter), "UNKNOWN TASK"},
+		{"auditor", as("carol", "acme", roleAuditor), statusInProgress},
+		{"operator of another tenant", as("alice", defaultTenant, roleOperator), "UNKNOWN TASK"},
+	}
+	for _, tt := range tests {
+		got, err := s.CheckTaskStatus(tt.ctx, &pb.StatusRequest{TaskId: res.TaskId})
+		if err != nil {
+			t.Fatalf("%s: CheckTaskStatus: %v", tt.name, err)
+		}
+		if got.Status != tt.want {
+			t.Errorf("%s: status %q, want %q", tt.name, got.Status, tt.want)
+		}
+	}
+}
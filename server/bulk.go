@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Actions accepted by BulkOperation.
+const (
+	bulkCancel       = "CANCEL"
+	bulkRetry        = "RETRY"
+	bulkDelete       = "DELETE"
+	bulkReprioritize = "REPRIORITIZE"
+)
+
+// bulkProgressInterval is the number of tasks handled between two progress
+// messages of a bulk operation.
+const bulkProgressInterval = 100
+
+// BulkOperation applies an action to every task of the caller's tenant
+// matching the request filter. Tasks the caller may not change are not
+// matched, so the counts reveal nothing about them.
+// Progress is streamed while the operation runs and the last message has Done
+// set. In dry-run mode only the number of matching tasks is reported.
+func (s *server) BulkOperation(req *pb.BulkOperationRequest, stream pb.TaskManager_BulkOperationServer) error {
+	switch req.Action {
+	case bulkCancel, bulkRetry, bulkDelete:
+	case bulkReprioritize:
+		if !validPriority(req.Priority) {
+			return status.Errorf(codes.InvalidArgument, "invalid priority %q", req.Priority)
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown action %q", req.Action)
+	}
+
+	s.mu.Lock()
+	tn, err := s.callerTenant(stream.Context())
+	if err == nil && req.Action == bulkReprioritize {
+		err = tn.checkPriority(req.Priority)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	filter := newTaskFilter(tn.name, req.Filter)
+	ids := s.matchingTasks(stream.Context(), filter)
+
+	progress := &pb.BulkOperationProgress{Matched: int32(len(ids))}
+	if req.DryRun {
+		progress.Done = true
+		return stream.Send(progress)
+	}
+
+	for _, id := range ids {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		if s.applyBulkAction(stream.Context(), id, filter, req) {
+			progress.Succeeded++
+		} else {
+			progress.Skipped++
+		}
+		progress.Processed++
+
+		if progress.Processed%bulkProgressInterval == 0 && int(progress.Processed) < len(ids) {
+			if err := stream.Send(progress); err != nil {
+				return err
+			}
+		}
+	}
+
+	progress.Done = true
+	return stream.Send(progress)
+}
+
+// applyBulkAction applies the requested action to a single task and reports
+// whether it took effect. The filter and the permission of the caller are
+// checked again because the task may have changed since the operation
+// started.
+func (s *server) applyBulkAction(ctx context.Context, taskID string, filter taskFilter, req *pb.BulkOperationRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, exists := s.tasks[taskID]
+	if !exists || !filter.matches(task) || !canChange(ctx, task) {
+		return false
+	}
+
+	switch req.Action {
+	case bulkCancel:
+		return s.cancelTask(task)
+	case bulkRetry:
+		return s.retryTask(ctx, task)
+	case bulkDelete:
+		s.deleteTask(task)
+		return true
+	case bulkReprioritize:
+		if task.status != statusQueued {
+			return false
+		}
+		task.record("priority changed from %s to %s", task.priority, req.Priority)
+		s.setPriority(task, req.Priority)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bulkStream records the progress messages of a bulk operation.
+type bulkStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*pb.BulkOperationProgress
+}
+
+func (s *bulkStream) Context() context.Context { return s.ctx }
+
+func (s *bulkStream) Send(p *pb.BulkOperationProgress) error {
+	s.sent = append(s.sent, p)
+	return nil
+}
+
+func TestBulkOperation(t *testing.T) {
+	s := newServer()
+	s.tenants[defaultTenant].queues[defaultQueue].paused = true
+	s.tenants["acme"] = newTenant("acme", tenantConfig{})
+
+	submitAs := func(name, priority string, labels map[string]string) *task {
+		t.Helper()
+		res, err := s.SubmitTask(as(name, defaultTenant, roleSubmitter), &pb.TaskRequest{TaskDescription: "test", Priority: priority, Labels: labels})
+		if err != nil {
+			t.Fatalf("SubmitTask as %s: %v", name, err)
+		}
+		return s.tasks[res.TaskId]
+	}
+	aliceLow := submitAs("alice", "LOW", nil)
+	aliceLabelled := submitAs("alice", "LOW", map[string]string{"team": "a"})
+	aliceHigh := submitAs("alice", "HIGH", nil)
+	bobLow := submitAs("bob", "LOW", nil)
+
+	// The steps run in order on the same tasks.
+	steps := []struct {
+		name string
+		ctx  context.Context
+		req  *pb.BulkOperationRequest
+		// matched, succeeded and skipped are the counts of the last
+		// progress message.
+		matched, succeeded, skipped int32
+		// statuses and priorities are those of aliceLow, aliceLabelled,
+		// aliceHigh and bobLow afterwards.
+		statuses   []string
+		priorities []string
+	}{{
+		name:       "dry run counts the tasks of the tenant",
+		ctx:        as("root", defaultTenant, roleAdmin),
+		req:        &pb.BulkOperationRequest{Action: bulkCancel, DryRun: true, Filter: &pb.TaskFilter{Priorities: []string{"LOW"}}},
+		matched:    3,
+		statuses:   []string{statusQueued, statusQueued, statusQueued, statusQueued},
+		priorities: []string{"LOW", "LOW", "HIGH", "LOW"},
+	}, {
+		name:       "dry run of an owner counts only their tasks",
+		ctx:        as("bob", defaultTenant, roleSubmitter),
+		req:        &pb.BulkOperationRequest{Action: bulkCancel, DryRun: true},
+		matched:    1,
+		statuses:   []string{statusQueued, statusQueued, statusQueued, statusQueued},
+		priorities: []string{"LOW", "LOW", "HIGH", "LOW"},
+	}, {
+		name:       "dry run of another tenant counts nothing",
+		ctx:        as("root", "acme", roleAdmin),
+		req:        &pb.BulkOperationRequest{Action: bulkCancel, DryRun: true},
+		matched:    0,
+		statuses:   []string{statusQueued, statusQueued, statusQueued, statusQueued},
+		priorities: []string{"LOW", "LOW", "HIGH", "LOW"},
+	}, {
+		name:       "cancel of an owner changes only their tasks",
+		ctx:        as("bob", defaultTenant, roleSubmitter),
+		req:        &pb.BulkOperationRequest{Action: bulkCancel},
+		matched:    1,
+		succeeded:  1,
+		statuses:   []string{statusQueued, statusQueued, statusQueued, statusCancelled},
+		priorities: []string{"LOW", "LOW", "HIGH", "LOW"},
+	}, {
+		name:       "reprioritize skips tasks no longer queued",
+		ctx:        as("root", defaultTenant, roleAdmin),
+		req:        &pb.BulkOperationRequest{Action: bulkReprioritize, Priority: "HIGH", Filter: &pb.TaskFilter{Priorities: []string{"LOW"}}},
+		matched:    3,
+		succeeded:  2,
+		skipped:    1,
+		statuses:   []string{statusQueued, statusQueued, statusQueued, statusCancelled},
+		priorities: []string{"HIGH", "HIGH", "HIGH", "LOW"},
+	}, {
+		name:       "cancel by label",
+		ctx:        as("root", defaultTenant, roleAdmin),
+		req:        &pb.BulkOperationRequest{Action: bulkCancel, Filter: &pb.TaskFilter{Labels: map[string]string{"team": "a"}}},
+		matched:    1,
+		succeeded:  1,
+		statuses:   []string{statusQueued, statusCancelled, statusQueued, statusCancelled},
+		priorities: []string{"HIGH", "HIGH", "HIGH", "LOW"},
+	}}
+	for _, tt := range steps {
+		stream := &bulkStream{ctx: tt.ctx}
+		if err := s.BulkOperation(tt.req, stream); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		last := stream.sent[len(stream.sent)-1]
+		if !last.Done || last.Matched != tt.matched || last.Succeeded != tt.succeeded || last.Skipped != tt.skipped {
+			t.Errorf("%s: last progress %v, want done with %d matched, %d succeeded and %d skipped", tt.name, last, tt.matched, tt.succeeded, tt.skipped)
+		}
+		if !tt.req.DryRun && last.Processed != last.Succeeded+last.Skipped {
+			t.Errorf("%s: %d tasks processed, but %d succeeded and %d skipped", tt.name, last.Processed, last.Succeeded, last.Skipped)
+		}
+		for i, task := range []*task{aliceLow, aliceLabelled, aliceHigh, bobLow} {
+			if task.status != tt.statuses[i] || task.priority != tt.priorities[i] {
+				t.Errorf("%s: task %d is %s %s, want %s %s", tt.name, i, task.status, task.priority, tt.statuses[i], tt.priorities[i])
+			}
+		}
+	}
+
+	// The reprioritized task now runs before the task that was HIGH from the
+	// start, as it was submitted earlier.
+	q := s.tenants[defaultTenant].queues[defaultQueue]
+	if got, want := pendingOrder(s, q), []string{aliceLow.id, aliceHigh.id}; !slices.Equal(got, want) {
+		t.Errorf("queue order %v, want %v", got, want)
+	}
+}
+
+func TestBulkOperationRejectsInvalidRequests(t *testing.T) {
+	s := newServer()
+	for _, req := range []*pb.BulkOperationRequest{
+		{Action: "ARCHIVE"},
+		{Action: bulkReprioritize, Priority: "URGENT"},
+	} {
+		err := s.BulkOperation(req, &bulkStream{ctx: context.Background()})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("BulkOperation(%v) returned %v, want %v", req, err, codes.InvalidArgument)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maciekb2/task-manager/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the environment variables read by the server.
+// A setting named "grpc-addr" is read from TASKMANAGER_GRPC_ADDR.
+const envPrefix = "TASKMANAGER_"
+
+// config holds the server configuration. Values are taken from the defaults,
+// then the configuration file, then the environment and finally the command
+// line, each overriding the previous one.
+type config struct {
+	// GRPCAddr is the address the gRPC API listens on.
+	GRPCAddr string `yaml:"grpc_addr"`
+	// HTTPAddr is the address of the metrics and health check server.
+	HTTPAddr string `yaml:"http_addr"`
+	// DebugAddr, when set, is the loopback address of the server for
+	// profiling and inspecting the scheduler and the gRPC connections.
+	DebugAddr string `yaml:"debug_addr"`
+	// Reflection enables the gRPC server reflection service, so tools like
+	// grpcurl can call the API without the proto files.
+	Reflection bool        `yaml:"reflection"`
+	TLS        tlsConfig   `yaml:"tls"`
+	Auth       authConfig  `yaml:"auth"`
+	Audit      auditConfig `yaml:"audit"`
+	Log        logConfig   `yaml:"log"`
+	// Workers limits the number of tasks of the default queue of the
+	// default tenant running at once; 0 means unlimited.
+	Workers int `yaml:"workers"`
+	// Quota limits the submissions of the default tenant.
+	Quota     quotaConfig     `yaml:"quota"`
+	Admission admissionConfig `yaml:"admission"`
+	Store     storeConfig     `yaml:"store"`
+	Retention retentionConfig `yaml:"retention"`
+	Shutdown  shutdownConfig  `yaml:"shutdown"`
+}
+
+// tlsConfig enables TLS on the gRPC API. The certificate files are reloaded
+// when they change.
+type tlsConfig struct {
+	// CertFile and KeyFile hold the server certificate; TLS is off when
+	// they are empty.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile holds the CA bundle client certificates are verified
+	// against. When set, clients must present a valid certificate.
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// enabled reports whether the gRPC API is served over TLS.
+func (c tlsConfig) enabled() bool {
+	return c.CertFile != ""
+}
+
+// storeConfig selects where tasks are kept.
+type storeConfig struct {
+	// Backend is the task store; only "memory" is supported.
+	Backend string `yaml:"backend"`
+}
+
+// retentionConfig sets how long finished tasks are kept, per final status.
+// A zero duration keeps tasks forever.
+type retentionConfig struct {
+	Completed time.Duration `yaml:"completed"`
+	Failed    time.Duration `yaml:"failed"`
+	Cancelled time.Duration `yaml:"cancelled"`
+	// Interval is how often expired tasks are deleted.
+	Interval time.Duration `yaml:"interval"`
+	// ArchiveFile, when set, receives every expired task as a JSON line
+	// before it is deleted.
+	ArchiveFile string `yaml:"archive_file"`
+}
+
+// shutdownConfig controls the graceful shutdown of the server.
+type shutdownConfig struct {
+	// GracePeriod is how long running tasks may take to finish.
+	GracePeriod time.Duration `yaml:"grace_period"`
+	// Policy is applied to tasks still running after the grace period,
+	// "requeue" or "fail".
+	Policy string `yaml:"policy"`
+}
+
+// defaultConfig returns the configuration used when nothing is set.
+func defaultConfig() config {
+	return config{
+		GRPCAddr: ":50051",
+		HTTPAddr: ":8080",
+		Audit: auditConfig{
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
+		Log: logConfig{
+			Format: logging.FormatText,
+			Level:  "info",
+		},
+		Admission: admissionConfig{HighPriorityReserve: 0.1},
+		Store:     storeConfig{Backend: "memory"},
+		Retention: retentionConfig{
+			Completed: 24 * time.Hour,
+			Failed:    7 * 24 * time.Hour,
+			Cancelled: 24 * time.Hour,
+			Interval:  time.Minute,
+		},
+		Shutdown: shutdownConfig{
+			GracePeriod: 30 * time.Second,
+			Policy:      shutdownRequeue,
+		},
+	}
+}
+
+// setting is a configuration value that can be set from the environment and
+// the command line.
+type setting struct {
+	name  string
+	usage string
+	set   func(c *config, value string) error
+}
+
+// settings lists every value that can be set outside the configuration file.
+var settings = []setting{
+	{"grpc-addr", "address the gRPC API listens on", func(c *config, v string) error {
+		c.GRPCAddr = v
+		return nil
+	}},
+	{"http-addr", "address of the metrics and health check server", func(c *config, v string) error {
+		c.HTTPAddr = v
+		return nil
+	}},
+	{"tls-cert-file", "server certificate file, enables TLS", func(c *config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key-file", "server private key file", func(c *config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{"tls-client-ca-file", "CA bundle for verifying client certificates, enables mutual TLS", func(c *config, v string) error {
+		c.TLS.ClientCAFile = v
+		return nil
+	}},
+	{"auth-jwks-file", "JWKS file bearer tokens are verified against, enables authentication", func(c *config, v string) error {
+		c.Auth.JWKSFile = v
+		return nil
+	}},
+	{"auth-jwt-issuer", "issuer bearer tokens must carry", func(c *config, v string) error {
+		c.Auth.Issuer = v
+		return nil
+	}},
+	{"auth-jwt-audience", "audience bearer tokens must carry", func(c *config, v string) error {
+		c.Auth.Audience = v
+		return nil
+	}},
+	{"debug-addr", "loopback address of the pprof, scheduler and channelz debug server, empty to turn it off", func(c *config, v string) error {
+		c.DebugAddr = v
+		return nil
+	}},
+	{"reflection", "enable the gRPC server reflection service", func(c *config, v string) error {
+		return parseBool(v, &c.Reflection)
+	}},
+	{"audit-file", "JSON lines file mutating calls are recorded in, empty to turn the audit log off", func(c *config, v string) error {
+		c.Audit.File = v
+		return nil
+	}},
+	{"audit-max-size-mb", "size in MiB at which the audit file is rotated", func(c *config, v string) error {
+		return parseInt(v, &c.Audit.MaxSizeMB)
+	}},
+	{"audit-max-backups", "number of rotated audit files kept", func(c *config, v string) error {
+		return parseInt(v, &c.Audit.MaxBackups)
+	}},
+	{"log-format", `log format, "text" or "json"`, func(c *config, v string) error {
+		c.Log.Format = v
+		return nil
+	}},
+	{"log-level", `lowest level logged: "debug", "info", "warn" or "error"`, func(c *config, v string) error {
+		c.Log.Level = v
+		return nil
+	}},
+	{"workers", "maximum number of running tasks of the default queue, 0 for unlimited", func(c *config, v string) error {
+		return parseInt(v, &c.Workers)
+	}},
+	{"quota-submit-rate", "tasks per second the default tenant may submit, 0 for unlimited", func(c *config, v string) error {
+		return parseFloat(v, &c.Quota.SubmitRate)
+	}},
+	{"quota-submit-burst", "tasks the default tenant may submit at once above its rate", func(c *config, v string) error {
+		return parseInt(v, &c.Quota.SubmitBurst)
+	}},
+	{"quota-caller-submit-rate", "tasks per second each caller of the default tenant may submit, 0 for unlimited", func(c *config, v string) error {
+		return parseFloat(v, &c.Quota.CallerSubmitRate)
+	}},
+	{"quota-caller-submit-burst", "tasks each caller of the default tenant may submit at once above its rate", func(c *config, v string) error {
+		return parseInt(v, &c.Quota.CallerSubmitBurst)
+	}},
+	{"quota-max-queued", "maximum QUEUED tasks of the default tenant, 0 for unlimited", func(c *config, v string) error {
+		return parseInt(v, &c.Quota.MaxQueued)
+	}},
+	{"quota-max-in-flight", "maximum QUEUED and IN_PROGRESS tasks of the default tenant, 0 for unlimited", func(c *config, v string) error {
+		return parseInt(v, &c.Quota.MaxInFlight)
+	}},
+	{"admission-max-queued", "QUEUED tasks across all tenants at which the server is overloaded, 0 for unlimited", func(c *config, v string) error {
+		return parseInt(v, &c.Admission.MaxQueued)
+	}},
+	{"admission-max-in-flight", "QUEUED and IN_PROGRESS tasks across all tenants at which the server is overloaded, 0 for unlimited", func(c *config, v string) error {
+		return parseInt(v, &c.Admission.MaxInFlight)
+	}},
+	{"admission-max-memory-mb", "heap size in MiB at which the server is overloaded, 0 for unlimited", func(c *config, v string) error {
+		return parseInt(v, &c.Admission.MaxMemoryMB)
+	}},
+	{"admission-high-priority-reserve", "share of the admission thresholds reserved for HIGH priority tasks", func(c *config, v string) error {
+		return parseFloat(v, &c.Admission.HighPriorityReserve)
+	}},
+	{"store-backend", `task store, only "memory" is supported`, func(c *config, v string) error {
+		c.Store.Backend = v
+		return nil
+	}},
+	{"retention-completed", "how long COMPLETED tasks are kept, 0 for forever", func(c *config, v string) error {
+		return parseDuration(v, &c.Retention.Completed)
+	}},
+	{"retention-failed", "how long FAILED tasks are kept, 0 for forever", func(c *config, v string) error {
+		return parseDuration(v, &c.Retention.Failed)
+	}},
+	{"retention-cancelled", "how long CANCELLED tasks are kept, 0 for forever", func(c *config, v string) error {
+		return parseDuration(v, &c.Retention.Cancelled)
+	}},
+	{"retention-interval", "how often expired tasks are deleted", func(c *config, v string) error {
+		return parseDuration(v, &c.Retention.Interval)
+	}},
+	{"retention-archive-file", "JSON lines file expired tasks are appended to before they are deleted", func(c *config, v string) error {
+		c.Retention.ArchiveFile = v
+		return nil
+	}},
+	{"shutdown-grace-period", "how long running tasks may take to finish when the server is stopped", func(c *config, v string) error {
+		return parseDuration(v, &c.Shutdown.GracePeriod)
+	}},
+	{"shutdown-policy", `what to do with tasks still running after the grace period: "requeue" or "fail"`, func(c *config, v string) error {
+		c.Shutdown.Policy = v
+		return nil
+	}},
+}
+
+// envName returns the environment variable a setting is read from.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func parseInt(v string, dst *int) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func parseFloat(v string, dst *float64) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return err
+	}
+	*dst = f
+	return nil
+}
+
+func parseBool(v string, dst *bool) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*dst = b
+	return nil
+}
+
+func parseDuration(v string, dst *time.Duration) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
+
+// options are the command line arguments of the server.
+type options struct {
+	configFile  string
+	printConfig bool
+	// flags holds the settings given on the command line, in order.
+	flags []flagValue
+}
+
+type flagValue struct {
+	setting setting
+	value   string
+}
+
+// parseOptions parses the command line arguments.
+func parseOptions(args []string) (options, error) {
+	var opts options
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", os.Getenv(envPrefix+"CONFIG"),
+		"path of a YAML or JSON configuration file (env "+envPrefix+"CONFIG)")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "print the effective configuration and exit")
+	for _, s := range settings {
+		fs.Func(s.name, fmt.Sprintf("%s (env %s)", s.usage, envName(s.name)), func(v string) error {
+			var c config
+			if err := s.set(&c, v); err != nil {
+				return err
+			}
+			opts.flags = append(opts.flags, flagValue{setting: s, value: v})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return opts, nil
+}
+
+// loadConfig builds the configuration from the defaults, the configuration
+// file, the environment and the command line, and validates it.
+func loadConfig(opts options) (config, error) {
+	cfg := defaultConfig()
+
+	if opts.configFile != "" {
+		data, err := os.ReadFile(opts.configFile)
+		if err != nil {
+			return cfg, err
+		}
+		// JSON is a subset of YAML, so both formats are read the same way.
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+			return cfg, fmt.Errorf("%s: %w", opts.configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(envName(s.name)); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return cfg, fmt.Errorf("%s: %w", envName(s.name), err)
+			}
+		}
+	}
+
+	for _, f := range opts.flags {
+		if err := f.setting.set(&cfg, f.value); err != nil {
+			return cfg, fmt.Errorf("-%s: %w", f.setting.name, err)
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+// validate checks that the configuration is usable.
+func (c config) validate() error {
+	var errs []error
+	if c.GRPCAddr == "" {
+		errs = append(errs, errors.New("grpc_addr must be set"))
+	}
+	if c.HTTPAddr == "" {
+		errs = append(errs, errors.New("http_addr must be set"))
+	}
+	if c.DebugAddr != "" {
+		if err := validateDebugAddr(c.DebugAddr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls cert_file and key_file must be set together"))
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.enabled() {
+		errs = append(errs, errors.New("tls client_ca_file requires cert_file and key_file"))
+	}
+	if err := c.Audit.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Log.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Workers < 0 {
+		errs = append(errs, errors.New("workers must not be negative"))
+	}
+	if err := c.Quota.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Admission.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Store.Backend != "memory" {
+		errs = append(errs, fmt.Errorf("unsupported store backend %q", c.Store.Backend))
+	}
+	if c.Retention.Completed < 0 || c.Retention.Failed < 0 || c.Retention.Cancelled < 0 {
+		errs = append(errs, errors.New("retention must not be negative"))
+	}
+	if c.Retention.Interval <= 0 {
+		errs = append(errs, errors.New("retention interval must be positive"))
+	}
+	if c.Shutdown.GracePeriod < 0 {
+		errs = append(errs, errors.New("shutdown grace period must not be negative"))
+	}
+	if c.Shutdown.Policy != shutdownRequeue && c.Shutdown.Policy != shutdownFail {
+		errs = append(errs, fmt.Errorf("invalid shutdown policy %q", c.Shutdown.Policy))
+	}
+	return errors.Join(errs...)
+}
+
+// print writes the configuration as YAML.
+func (c config) print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// reloadable returns the settings of c that can be changed without a restart,
+// applied on top of the running configuration. It also returns the names of
+// the changed settings that only take effect after a restart.
+func (c config) reloadable(running config) (config, []string) {
+	var ignored []string
+	if c.GRPCAddr != running.GRPCAddr {
+		ignored = append(ignored, "grpc_addr")
+	}
+	if c.HTTPAddr != running.HTTPAddr {
+		ignored = append(ignored, "http_addr")
+	}
+	if c.DebugAddr != running.DebugAddr {
+		ignored = append(ignored, "debug_addr")
+	}
+	if c.Reflection != running.Reflection {
+		ignored = append(ignored, "reflection")
+	}
+	if c.TLS != running.TLS {
+		ignored = append(ignored, "tls")
+	}
+	if c.Audit != running.Audit {
+		ignored = append(ignored, "audit")
+	}
+	if c.Store != running.Store {
+		ignored = append(ignored, "store")
+	}
+	if c.Log.Format != running.Log.Format {
+		ignored = append(ignored, "log.format")
+	}
+
+	running.Auth = c.Auth
+	running.Log.Level = c.Log.Level
+	running.Workers = c.Workers
+	running.Quota = c.Quota
+	running.Admission = c.Admission
+	running.Retention = c.Retention
+	running.Shutdown = c.Shutdown
+	return running, ignored
+}
+
+// applyConfig applies the settings the server itself depends on.
+func (s *server) applyConfig(cfg config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tn := s.tenants[defaultTenant]
+	if cfg.Quota != tn.config.quota {
+		tn.setQuota(cfg.Quota)
+	}
+	q := tn.queues[defaultQueue]
+	if cfg.Workers != s.workers {
+		s.workers = cfg.Workers
+		q.config.maxConcurrency = cfg.Workers
+	}
+	s.retention = cfg.Retention
+	if level, err := logging.ParseLevel(cfg.Log.Level); err == nil {
+		logLevel.Set(level)
+	}
+	s.admission.config = cfg.Admission
+	s.dispatch(q)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+)
+
+// writeConfigFile writes a configuration file and returns its path.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	const file = `
+grpc_addr: ":1001"
+workers: 1
+log:
+  level: warn
+shutdown:
+  grace_period: 1s
+`
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		flags []string
+		want  config
+	}{{
+		name: "defaults",
+		want: config{GRPCAddr: ":50051", Workers: 0, Log: logConfig{Level: "info"}, Shutdown: shutdownConfig{GracePeriod: 30 * time.Second}},
+	}, {
+		name: "file over defaults",
+		file: file,
+		want: config{GRPCAddr: ":1001", Workers: 1, Log: logConfig{Level: "warn"}, Shutdown: shutdownConfig{GracePeriod: time.Second}},
+	}, {
+		name: "env over file",
+		file: file,
+		env:  map[string]string{"TASKMANAGER_GRPC_ADDR": ":1002", "TASKMANAGER_WORKERS": "2"},
+		want: config{GRPCAddr: ":1002", Workers: 2, Log: logConfig{Level: "warn"}, Shutdown: shutdownConfig{GracePeriod: time.Second}},
+	}, {
+		name:  "flags over env",
+		file:  file,
+		env:   map[string]string{"TASKMANAGER_GRPC_ADDR": ":1002", "TASKMANAGER_WORKERS": "2"},
+		flags: []string{"-grpc-addr", ":1003", "-log-level", "error"},
+		want:  config{GRPCAddr: ":1003", Workers: 2, Log: logConfig{Level: "error"}, Shutdown: shutdownConfig{GracePeriod: time.Second}},
+	}, {
+		name:  "flags over defaults",
+		flags: []string{"-shutdown-grace-period", "5s"},
+		want:  config{GRPCAddr: ":50051", Workers: 0, Log: logConfig{Level: "info"}, Shutdown: shutdownConfig{GracePeriod: 5 * time.Second}},
+	}, {
+		name: "JSON file",
+		file: `{"grpc_addr": ":1004", "shutdown": {"grace_period": "2s"}}`,
+		want: config{GRPCAddr: ":1004", Workers: 0, Log: logConfig{Level: "info"}, Shutdown: shutdownConfig{GracePeriod: 2 * time.Second}},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.flags
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, "config.yaml", tt.file)}, args...)
+			}
+			opts, err := parseOptions(args)
+			if err != nil {
+				t.Fatalf("parseOptions(%q): %v", args, err)
+			}
+			cfg, err := loadConfig(opts)
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if cfg.GRPCAddr != tt.want.GRPCAddr {
+				t.Errorf("grpc_addr = %q, want %q", cfg.GRPCAddr, tt.want.GRPCAddr)
+			}
+			if cfg.Workers != tt.want.Workers {
+				t.Errorf("workers = %d, want %d", cfg.Workers, tt.want.Workers)
+			}
+			if cfg.Log.Level != tt.want.Log.Level {
+				t.Errorf("log.level = %q, want %q", cfg.Log.Level, tt.want.Log.Level)
+			}
+			if cfg.Shutdown.GracePeriod != tt.want.Shutdown.GracePeriod {
+				t.Errorf("shutdown.grace_period = %s, want %s", cfg.Shutdown.GracePeriod, tt.want.Shutdown.GracePeriod)
+			}
+			// Settings no layer touched keep their defaults.
+			if cfg.HTTPAddr != ":8080" {
+				t.Errorf("http_addr = %q, want the default %q", cfg.HTTPAddr, ":8080")
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		flags []string
+		// want is part of the expected error.
+		want string
+	}{{
+		name: "unknown key",
+		file: "grpc_addr: \":1001\"\nworkerz: 4\n",
+		want: "field workerz not found",
+	}, {
+		name: "unknown nested key",
+		file: "shutdown:\n  grace: 1s\n",
+		want: "field grace not found",
+	}, {
+		name: "unknown JSON key",
+		file: `{"log": {"colour": true}}`,
+		want: "field colour not found",
+	}, {
+		name: "invalid env value",
+		env:  map[string]string{"TASKMANAGER_WORKERS": "many"},
+		want: "TASKMANAGER_WORKERS",
+	}, {
+		name:  "invalid value",
+		flags: []string{"-workers", "-1"},
+		want:  "workers must not be negative",
+	}, {
+		name: "invalid shutdown policy",
+		env:  map[string]string{"TASKMANAGER_SHUTDOWN_POLICY": "drop"},
+		want: `invalid shutdown policy "drop"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.flags
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, "config.yaml", tt.file)}, args...)
+			}
+			opts, err := parseOptions(args)
+			if err != nil {
+				t.Fatalf("parseOptions(%q): %v", args, err)
+			}
+			_, err = loadConfig(opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig returned %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOptionsRejectsInvalidFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-workers", "many"},
+		{"-shutdown-grace-period", "soon"},
+		{"-no-such-flag"},
+		{"extra"},
+	} {
+		if _, err := parseOptions(args); err == nil {
+			t.Errorf("parseOptions(%q) succeeded, want an error", args)
+		}
+	}
+}
+
+func TestApplyConfigKeepsQueueUpdates(t *testing.T) {
+	s := newServer()
+	q := s.tenants[defaultTenant].queues[defaultQueue]
+	cfg := defaultConfig()
+	cfg.Workers = 2
+	s.applyConfig(cfg)
+	if q.config.maxConcurrency != 2 {
+		t.Fatalf("default queue runs %d tasks at once, want 2", q.config.maxConcurrency)
+	}
+
+	req := &pb.UpdateQueueRequest{Queue: &pb.Queue{Name: defaultQueue, MaxConcurrency: 5}, UpdateMask: mask("max_concurrency")}
+	if _, err := s.UpdateQueue(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	// A reload leaves the limit set over the API alone while workers is
+	// unchanged.
+	s.applyConfig(cfg)
+	if q.config.maxConcurrency != 5 {
+		t.Errorf("reload reset the default queue to %d tasks at once, want 5", q.config.maxConcurrency)
+	}
+	cfg.Workers = 3
+	s.applyConfig(cfg)
+	if q.config.maxConcurrency != 3 {
+		t.Errorf("default queue runs %d tasks at once after workers changed, want 3", q.config.maxConcurrency)
+	}
+}
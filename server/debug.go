@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"slices"
+	"strings"
+	"time"
+)
+
+// validateDebugAddr checks that the debug server only listens on a loopback
+// address, as its endpoints are not authenticated.
+func validateDebugAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid debug_addr: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("debug_addr %q must listen on localhost or a loopback address", addr)
+	}
+	return nil
+}
+
+// newDebugServer returns the debug server. It speaks HTTP/2 without TLS as
+// well, as gRPC clients do.
+func newDebugServer(addr string, handler http.Handler) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{Addr: addr, Handler: handler, Protocols: &protocols}
+}
+
+// debugHandler returns the handler of the debug server: the pprof profiles
+// under /debug/pprof/, a dump of all goroutines under /debug/goroutines, the
+// state of the scheduler under /debug/scheduler and gRPC calls, served by
+// grpcServer.
+func (s *server) debugHandler(grpcServer http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	mux.HandleFunc("/debug/scheduler", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(s.schedulerState(time.Now()))
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// schedulerState is a snapshot of the internals of the scheduler.
+type schedulerState struct {
+	Paused       bool             `json:"paused"`
+	ShuttingDown bool             `json:"shutting_down"`
+	Goroutines   int              `json:"goroutines"`
+	Tenants      []tenantState    `json:"tenants"`
+	Running      []runningTask    `json:"running_tasks"`
+	Subscribers  []subscriberInfo `json:"subscribers"`
+}
+
+type tenantState struct {
+	Name           string       `json:"name"`
+	Running        int          `json:"running"`
+	MaxConcurrency int          `json:"max_concurrency"`
+	Queues         []queueState `json:"queues"`
+}
+
+type queueState struct {
+	Name           string `json:"name"`
+	Pending        int    `json:"pending"`
+	Running        int    `json:"running"`
+	MaxConcurrency int    `json:"max_concurrency"`
+	Paused         bool   `json:"paused"`
+	Draining       bool   `json:"draining"`
+}
+
+// runningTask is a task that occupies a slot of its queue.
+type runningTask struct {
+	ID         string    `json:"id"`
+	Tenant     string    `json:"tenant"`
+	Queue      string    `json:"queue"`
+	Priority   string    `json:"priority"`
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	RunningFor string    `json:"running_for"`
+}
+
+// subscriberInfo describes the status streams following a task.
+type subscriberInfo struct {
+	TaskID string `json:"task_id"`
+	// Streams is the number of open StreamTaskStatus streams, and Buffered
+	// the number of status updates none of them has received yet.
+	Streams  int `json:"streams"`
+	Buffered int `json:"buffered"`
+}
+
+// schedulerState returns the state of the queues, the running tasks and the
+// status streams, sorted for stable output.
+func (s *server) schedulerState(now time.Time) schedulerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := schedulerState{
+		Paused:       s.paused,
+		ShuttingDown: s.shuttingDown,
+		Goroutines:   runtime.NumGoroutine(),
+		Running:      []runningTask{},
+		Subscribers:  []subscriberInfo{},
+	}
+	for _, tn := range s.tenants {
+		ts := tenantState{Name: tn.name, Running: tn.running, MaxConcurrency: tn.config.maxConcurrency}
+		for _, q := range tn.queues {
+			ts.Queues = append(ts.Queues, queueState{
+				Name:           q.name,
+				Pending:        q.pending.Len(),
+				Running:        q.running,
+				MaxConcurrency: q.config.maxConcurrency,
+				Paused:         q.paused,
+				Draining:       q.draining,
+			})
+		}
+		slices.SortFunc(ts.Queues, func(a, b queueState) int { return strings.Compare(a.Name, b.Name) })
+		state.Tenants = append(state.Tenants, ts)
+	}
+	slices.SortFunc(state.Tenants, func(a, b tenantState) int { return strings.Compare(a.Name, b.Name) })
+
+	for _, t := range s.tasks {
+		if t.status != statusInProgress {
+			continue
+		}
+		state.Running = append(state.Running, runningTask{
+			ID:         t.id,
+			Tenant:     t.tenant,
+			Queue:      t.queue,
+			Priority:   t.priority,
+			Attempt:    t.attempt,
+			StartedAt:  t.startedAt,
+			RunningFor: now.Sub(t.startedAt).Round(time.Millisecond).String(),
+		})
+	}
+	slices.SortFunc(state.Running, func(a, b runningTask) int { return a.StartedAt.Compare(b.StartedAt) })
+
+	for id, n := range s.streams {
+		state.Subscribers = append(state.Subscribers, subscriberInfo{TaskID: id, Streams: n, Buffered: len(s.subscribers[id])})
+	}
+	slices.SortFunc(state.Subscribers, func(a, b subscriberInfo) int { return strings.Compare(a.TaskID, b.TaskID) })
+	return state
+}
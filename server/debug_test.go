@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestValidateDebugAddr(t *testing.T) {
+	for addr, valid := range map[string]bool{
+		"localhost:6060": true,
+		"127.0.0.1:6060": true,
+		"[::1]:6060":     true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.1:6060":  false,
+		"localhost":      false,
+	} {
+		if err := validateDebugAddr(addr); (err == nil) != valid {
+			t.Errorf("validateDebugAddr(%q) = %v, want valid %t", addr, err, valid)
+		}
+	}
+}
+
+func TestDebugServerServesChannelz(t *testing.T) {
+	s := newServer()
+	channelzServer := grpc.NewServer()
+	channelzsvc.RegisterChannelzServiceToServer(channelzServer)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	debugServer := newDebugServer(lis.Addr().String(), s.debugHandler(channelzServer))
+	go debugServer.Serve(lis)
+	t.Cleanup(func() { debugServer.Close() })
+
+	// Plain HTTP requests reach the debug endpoints.
+	res, err := http.Get("http://" + lis.Addr().String() + "/debug/scheduler")
+	if err != nil {
+		t.Fatalf("GET /debug/scheduler: %v", err)
+	}
+	defer res.Body.Close()
+	var state schedulerState
+	if err := json.NewDecoder(res.Body).Decode(&state); err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("GET /debug/scheduler = %s, %v, want the scheduler state", res.Status, err)
+	}
+
+	// gRPC calls reach channelz.
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := channelzpb.NewChannelzClient(conn).GetServers(context.Background(), &channelzpb.GetServersRequest{}); err != nil {
+		t.Errorf("GetServers on the debug server: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+)
+
+// taskFilter selects tasks of a tenant in the store. It is the server-side
+// form of pb.TaskFilter; empty fields match every task of the tenant.
+type taskFilter struct {
+	tenant        string
+	statuses      map[string]bool
+	priorities    map[string]bool
+	labels        map[string]string
+	createdAfter  time.Time
+	createdBefore time.Time
+}
+
+// newTaskFilter converts a filter received over the API, limiting it to the
+// tasks of the tenant.
+func newTaskFilter(tenant string, f *pb.TaskFilter) taskFilter {
+	filter := taskFilter{tenant: tenant, labels: f.GetLabels()}
+	if len(f.GetStatuses()) > 0 {
+		filter.statuses = make(map[string]bool)
+		for _, status := range f.GetStatuses() {
+			filter.statuses[status] = true
+		}
+	}
+	if len(f.GetPriorities()) > 0 {
+		filter.priorities = make(map[string]bool)
+		for _, priority := range f.GetPriorities() {
+			filter.priorities[priority] = true
+		}
+	}
+	if f.GetCreatedAfter() != nil {
+		filter.createdAfter = f.GetCreatedAfter().AsTime()
+	}
+	if f.GetCreatedBefore() != nil {
+		filter.createdBefore = f.GetCreatedBefore().AsTime()
+	}
+	return filter
+}
+
+// matches reports whether the task is selected by the filter.
+// The caller must hold the server lock.
+func (f taskFilter) matches(t *task) bool {
+	if t.tenant != f.tenant {
+		return false
+	}
+	if f.statuses != nil && !f.statuses[t.status] {
+		return false
+	}
+	if f.priorities != nil && !f.priorities[t.priority] {
+		return false
+	}
+	for key, value := range f.labels {
+		if v, ok := t.labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	if !f.createdAfter.IsZero() && t.createdAt.Before(f.createdAfter) {
+		return false
+	}
+	if !f.createdBefore.IsZero() && !t.createdAt.Before(f.createdBefore) {
+		return false
+	}
+	return true
+}
+
+// matchingTasks returns the IDs of the tasks selected by the filter that the
+// caller in ctx may change. Filters on labels only look at the tasks found in
+// the label index of the tenant.
+func (s *server) matchingTasks(ctx context.Context, f taskFilter) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	if tn := s.tenants[f.tenant]; tn != nil && len(f.labels) > 0 {
+		for id := range tn.tasksWithLabels(f.labels) {
+			if task := s.tasks[id]; f.matches(task) && canChange(ctx, task) {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	for id, task := range s.tasks {
+		if f.matches(task) && canChange(ctx, task) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheckInterval is how often the readiness is copied to the gRPC health
+// service.
+const healthCheckInterval = time.Second
+
+// livenessTimeout is how long the server lock may stay held before the server
+// is considered stuck.
+const livenessTimeout = 5 * time.Second
+
+// componentHealth is the state of one part of the server.
+type componentHealth struct {
+	Healthy bool   `json:"healthy"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// healthReport is the state of the server with the components it was derived
+// from.
+type healthReport struct {
+	Healthy    bool                       `json:"healthy"`
+	Components map[string]componentHealth `json:"components"`
+}
+
+// newHealthReport returns a report that is healthy when all components are.
+func newHealthReport(components map[string]componentHealth) healthReport {
+	report := healthReport{Healthy: true, Components: components}
+	for _, c := range components {
+		report.Healthy = report.Healthy && c.Healthy
+	}
+	return report
+}
+
+// liveness reports whether the server is able to make progress, that is
+// whether its lock can be taken.
+func (s *server) liveness() healthReport {
+	locked := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		close(locked)
+	}()
+
+	lock := componentHealth{Healthy: true}
+	select {
+	case <-locked:
+	case <-time.After(livenessTimeout):
+		lock = componentHealth{Detail: fmt.Sprintf("server lock held for more than %s", livenessTimeout)}
+	}
+	return newHealthReport(map[string]componentHealth{"lock": lock})
+}
+
+// readiness reports whether the server should receive new tasks: the task
+// store is reachable, the reaper is running, the server is not shutting down
+// and it still accepts HIGH priority tasks.
+func (s *server) readiness() healthReport {
+	overloaded, reason := s.overloaded()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	components := map[string]componentHealth{
+		// Tasks are kept in memory, so the store is always reachable.
+		"store":     {Healthy: true, Detail: "memory"},
+		"reaper":    {Healthy: s.reaperRunning},
+		"intake":    {Healthy: !s.shuttingDown},
+		"admission": {Healthy: !overloaded, Detail: reason},
+	}
+	if !s.reaperRunning {
+		components["reaper"] = componentHealth{Detail: "not running"}
+	}
+	if s.shuttingDown {
+		components["intake"] = componentHealth{Detail: "shutting down"}
+	}
+	return newHealthReport(components)
+}
+
+// healthHandler serves a health report as JSON, with status 503 when the
+// server is not healthy.
+func healthHandler(report func() healthReport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := report()
+		w.Header().Set("Content-Type", "application/json")
+		if !res.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// runHealth copies the readiness of the server to the gRPC health service,
+// for the whole server and the TaskManager service, until the server shuts
+// down. Then both are reported as not serving.
+func (s *server) runHealth(hs *health.Server) {
+	update := func() {
+		st := healthpb.HealthCheckResponse_SERVING
+		if !s.readiness().Healthy {
+			st = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus("", st)
+		hs.SetServingStatus(pb.TaskManager_ServiceDesc.ServiceName, st)
+	}
+
+	update()
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			update()
+		case <-s.stopping:
+			hs.Shutdown()
+			return
+		}
+	}
+}
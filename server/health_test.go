@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve serves the server and a health service over an in-memory listener
+// with the interceptors of the API, and returns a connection to it.
+func serve(t *testing.T, s *server) (*grpc.ClientConn, *health.Server) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(s.interceptors()...)
+	pb.RegisterTaskManagerServer(grpcServer, s)
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, hs)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, hs
+}
+
+func TestHealthWithoutCredentials(t *testing.T) {
+	s := newServer()
+	if err := s.configureAuth(authConfig{APIKeys: []apiKeyConfig{{Name: "ci", SHA256: hashKey("ci-secret"), Roles: []string{roleViewer}}}}); err != nil {
+		t.Fatal(err)
+	}
+	conn, hs := serve(t, s)
+	hs.SetServingStatus(pb.TaskManager_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	ctx := context.Background()
+	client := healthpb.NewHealthClient(conn)
+
+	// Every RPC of the health service is reachable without credentials.
+	rpcs := map[string]func() error{
+		healthpb.Health_Check_FullMethodName: func() error {
+			res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: pb.TaskManager_ServiceDesc.ServiceName})
+			if err == nil && res.Status != healthpb.HealthCheckResponse_SERVING {
+				t.Errorf("Check = %v, want %v", res.Status, healthpb.HealthCheckResponse_SERVING)
+			}
+			return err
+		},
+		healthpb.Health_List_FullMethodName: func() error {
+			res, err := client.List(ctx, &healthpb.HealthListRequest{})
+			if err == nil && res.Statuses[pb.TaskManager_ServiceDesc.ServiceName].GetStatus() != healthpb.HealthCheckResponse_SERVING {
+				t.Errorf("List = %v, want %s serving", res.Statuses, pb.TaskManager_ServiceDesc.ServiceName)
+			}
+			return err
+		},
+		healthpb.Health_Watch_FullMethodName: func() error {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		},
+	}
+	for _, method := range healthpb.Health_ServiceDesc.Methods {
+		checkPublic(t, rpcs, "/"+healthpb.Health_ServiceDesc.ServiceName+"/"+method.MethodName)
+	}
+	for _, stream := range healthpb.Health_ServiceDesc.Streams {
+		checkPublic(t, rpcs, "/"+healthpb.Health_ServiceDesc.ServiceName+"/"+stream.StreamName)
+	}
+
+	// The API itself still needs credentials.
+	_, err := pb.NewTaskManagerClient(conn).CheckTaskStatus(ctx, &pb.StatusRequest{TaskId: "42"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("CheckTaskStatus without credentials returned %v, want %v", err, codes.Unauthenticated)
+	}
+}
+
+// checkPublic calls the health RPC and checks that it succeeds without
+// credentials.
+func checkPublic(t *testing.T, rpcs map[string]func() error, method string) {
+	t.Helper()
+	call, ok := rpcs[method]
+	if !ok {
+		t.Errorf("%s is not tested", method)
+		return
+	}
+	if !publicMethods[method] {
+		t.Errorf("%s is not a public method", method)
+	}
+	if err := call(); err != nil {
+		t.Errorf("%s without credentials: %v", method, err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// historyStep is the resolution the activity of tasks is kept in, and
+	// historySpan how long it is kept.
+	historyStep = time.Minute
+	historySpan = 24 * time.Hour
+	// maxHistoryPoints bounds the number of points of a
+	// GetStatisticsHistory response.
+	maxHistoryPoints = 1440
+)
+
+// historyPriorities are the priorities reported by the history, indexed by
+// their priorityRank.
+var historyPriorities = [...]string{"HIGH", "MEDIUM", "LOW"}
+
+// activity counts the tasks of a priority that were submitted and finished
+// within a time span.
+type activity struct {
+	submitted, completed, failed, cancelled int
+	// runs is the number of tasks that completed or failed after running,
+	// and duration the sum of their run times.
+	runs     int
+	duration time.Duration
+}
+
+// add adds the counts of other to a.
+func (a *activity) add(other activity) {
+	a.submitted += other.submitted
+	a.completed += other.completed
+	a.failed += other.failed
+	a.cancelled += other.cancelled
+	a.runs += other.runs
+	a.duration += other.duration
+}
+
+func (a activity) proto() *pb.PriorityActivity {
+	res := &pb.PriorityActivity{
+		Submitted: int32(a.submitted),
+		Completed: int32(a.completed),
+		Failed:    int32(a.failed),
+		Cancelled: int32(a.cancelled),
+	}
+	if a.runs > 0 {
+		res.AverageDuration = durationpb.New(a.duration / time.Duration(a.runs))
+	}
+	return res
+}
+
+// historyBucket holds the activity of one step, by priority rank.
+type historyBucket struct {
+	start      time.Time
+	byPriority [len(historyPriorities)]activity
+}
+
+// history holds the activity of tasks over the last historySpan in steps of
+// historyStep. Only steps with activity are kept, oldest first.
+type history struct {
+	buckets []historyBucket
+}
+
+// at returns the activity of the priority in the step of now, dropping the
+// steps that are older than historySpan.
+func (h *history) at(now time.Time, priority string) *activity {
+	start := now.Truncate(historyStep)
+	if n := len(h.buckets); n == 0 || start.After(h.buckets[n-1].start) {
+		expired := 0
+		for expired < n && !h.buckets[expired].start.After(start.Add(-historySpan)) {
+			expired++
+		}
+		h.buckets = append(slices.Delete(h.buckets, 0, expired), historyBucket{start: start})
+	}
+	// A clock set back counts into the latest step.
+	b := &h.buckets[len(h.buckets)-1]
+	return &b.byPriority[min(priorityRank(priority), len(historyPriorities)-1)]
+}
+
+// recordSubmitted counts a new task in the history of its statistics. The
+// caller must hold s.mu.
+func (s *server) recordSubmitted(t *task, now time.Time) {
+	for _, st := range s.statsOf(t) {
+		st.history.at(now, t.priority).submitted++
+	}
+}
+
+// recordHistory counts a task moving to a final status in the history. The
+// caller must hold s.mu.
+func (st *taskStats) recordHistory(t *task, status string, now time.Time) {
+	if isTerminal(t.status) {
+		return
+	}
+	a := st.history.at(now, t.priority)
+	switch status {
+	case statusCompleted:
+		a.completed++
+	case statusFailed:
+		a.failed++
+	case statusCancelled:
+		a.cancelled++
+		return
+	default:
+		return
+	}
+	if t.status == statusInProgress {
+		a.runs++
+		a.duration += now.Sub(t.startedAt)
+	}
+}
+
+// GetStatisticsHistory returns the number of tasks of the caller's tenant
+// submitted and finished in every interval of a time range, per priority.
+// Callers that only see their own tasks get the history of those.
+func (s *server) GetStatisticsHistory(ctx context.Context, req *pb.StatisticsHistoryRequest) (*pb.StatisticsHistoryResponse, error) {
+	now := time.Now()
+	end := now
+	if req.EndTime != nil {
+		end = req.EndTime.AsTime()
+	}
+	start := end.Add(-time.Hour)
+	if req.StartTime != nil {
+		start = req.StartTime.AsTime()
+	}
+	resolution := historyStep
+	if req.Resolution != nil {
+		resolution = req.Resolution.AsDuration()
+	}
+	if resolution <= 0 || resolution%historyStep != 0 {
+		return nil, status.Error(codes.InvalidArgument, "resolution must be a whole number of minutes")
+	}
+	if !start.Before(end) {
+		return nil, status.Error(codes.InvalidArgument, "start_time must be before end_time")
+	}
+	start = start.Truncate(resolution)
+	points := int((end.Sub(start) + resolution - 1) / resolution)
+	if points > maxHistoryPoints {
+		return nil, status.Errorf(codes.InvalidArgument, "range covers %d points of %s, at most %d are returned", points, resolution, maxHistoryPoints)
+	}
+
+	sums := make([][len(historyPriorities)]activity, points)
+	s.mu.Lock()
+	tn, err := s.callerTenant(ctx)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if st := callerStats(ctx, tn); st != nil {
+		for _, b := range st.history.buckets {
+			if b.start.Before(start) {
+				continue
+			}
+			i := int(b.start.Sub(start) / resolution)
+			if i >= points {
+				break
+			}
+			for rank, a := range b.byPriority {
+				sums[i][rank].add(a)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	res := &pb.StatisticsHistoryResponse{Resolution: durationpb.New(resolution)}
+	for i, sum := range sums {
+		point := &pb.StatisticsPoint{
+			Time:       timestamppb.New(start.Add(time.Duration(i) * resolution)),
+			ByPriority: make(map[string]*pb.PriorityActivity),
+		}
+		for rank, a := range sum {
+			point.ByPriority[historyPriorities[rank]] = a.proto()
+		}
+		res.Points = append(res.Points, point)
+	}
+	return res, nil
+}
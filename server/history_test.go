@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestHistoryBuckets(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var h history
+	// Each step submits one task at the offset from base.
+	steps := []struct {
+		name   string
+		offset time.Duration
+		// starts are the offsets of the buckets kept afterwards.
+		starts []time.Duration
+	}{
+		{"first step", 10 * time.Second, []time.Duration{0}},
+		{"same step", 59 * time.Second, []time.Duration{0}},
+		{"next step", 90 * time.Second, []time.Duration{0, time.Minute}},
+		{"clock set back", 30 * time.Second, []time.Duration{0, time.Minute}},
+		{"steps without activity are skipped", 10 * time.Minute, []time.Duration{0, time.Minute, 10 * time.Minute}},
+		{"last step within 24 hours", 24*time.Hour - time.Minute, []time.Duration{0, time.Minute, 10 * time.Minute, 24*time.Hour - time.Minute}},
+		{"first step older than 24 hours", 24 * time.Hour, []time.Duration{time.Minute, 10 * time.Minute, 24*time.Hour - time.Minute, 24 * time.Hour}},
+		{"all steps older than 24 hours", 48 * time.Hour, []time.Duration{48 * time.Hour}},
+	}
+	for _, tt := range steps {
+		h.at(base.Add(tt.offset), "HIGH").submitted++
+		var starts []time.Duration
+		for _, b := range h.buckets {
+			starts = append(starts, b.start.Sub(base))
+		}
+		if len(starts) != len(tt.starts) {
+			t.Errorf("%s: buckets at %v, want %v", tt.name, starts, tt.starts)
+			continue
+		}
+		for i := range starts {
+			if starts[i] != tt.starts[i] {
+				t.Errorf("%s: buckets at %v, want %v", tt.name, starts, tt.starts)
+				break
+			}
+		}
+	}
+	// The first bucket was dropped; the second one got the task of the
+	// next step and the task recorded while the clock was set back.
+	if got := h.buckets[0].byPriority[priorityRank("HIGH")].submitted; got != 1 {
+		t.Errorf("bucket holds %d tasks, want 1", got)
+	}
+}
+
+func TestGetStatisticsHistory(t *testing.T) {
+	s := newServer()
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := &s.tenants[defaultTenant].stats.history
+	record := func(offset time.Duration, priority string, f func(a *activity)) {
+		f(h.at(base.Add(offset), priority))
+	}
+	record(0, "HIGH", func(a *activity) { a.submitted += 2 })
+	record(4*time.Minute, "HIGH", func(a *activity) { a.completed, a.runs, a.duration = 1, 1, 10*time.Second })
+	record(4*time.Minute, "LOW", func(a *activity) { a.cancelled++ })
+	record(6*time.Minute, "HIGH", func(a *activity) { a.failed, a.runs, a.duration = 1, 1, 30*time.Second })
+	record(20*time.Minute, "MEDIUM", func(a *activity) { a.submitted++ })
+
+	history := func(start, end time.Time, resolution time.Duration) (*pb.StatisticsHistoryResponse, error) {
+		return s.GetStatisticsHistory(context.Background(), &pb.StatisticsHistoryRequest{
+			StartTime:  timestamppb.New(start),
+			EndTime:    timestamppb.New(end),
+			Resolution: durationpb.New(resolution),
+		})
+	}
+
+	// A start within an interval is moved to its beginning, and the
+	// intervals cover the whole range.
+	res, err := history(base.Add(2*time.Minute), base.Add(14*time.Minute), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Points) != 3 || !res.Points[0].Time.AsTime().Equal(base) {
+		t.Fatalf("%d points from %v, want 3 from %v", len(res.Points), res.Points[0].Time.AsTime(), base)
+	}
+	want := []struct {
+		submitted, completed, failed, cancelled int32
+		average                                 time.Duration
+	}{
+		{2, 1, 0, 0, 10 * time.Second},
+		{0, 0, 1, 0, 30 * time.Second},
+		{0, 0, 0, 0, 0},
+	}
+	for i, w := range want {
+		got := res.Points[i].ByPriority["HIGH"]
+		if got.Submitted != w.submitted || got.Completed != w.completed || got.Failed != w.failed || got.Cancelled != w.cancelled || got.GetAverageDuration().AsDuration() != w.average {
+			t.Errorf("point %d: HIGH %v, want %+v", i, got, w)
+		}
+	}
+	if n := res.Points[0].ByPriority["LOW"].Cancelled; n != 1 {
+		t.Errorf("point 0: %d LOW tasks cancelled, want 1", n)
+	}
+	if n := len(res.Points[0].ByPriority); n != len(historyPriorities) {
+		t.Errorf("point 0 has %d priorities, want %d", n, len(historyPriorities))
+	}
+
+	// The limit on the number of points is checked against the resolution.
+	tests := []struct {
+		name       string
+		start      time.Time
+		resolution time.Duration
+		want       codes.Code
+	}{
+		{"24 hours by minute", base.Add(-maxHistoryPoints * time.Minute), time.Minute, codes.OK},
+		{"one minute more", base.Add(-(maxHistoryPoints + 1) * time.Minute), time.Minute, codes.InvalidArgument},
+		{"one minute more by hour", base.Add(-(maxHistoryPoints + 1) * time.Minute), time.Hour, codes.OK},
+		{"resolution not in minutes", base.Add(-time.Hour), 90 * time.Second, codes.InvalidArgument},
+		{"empty range", base, time.Minute, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		res, err := history(tt.start, base, tt.resolution)
+		if status.Code(err) != tt.want {
+			t.Errorf("%s: GetStatisticsHistory returned %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if err == nil && len(res.Points) > maxHistoryPoints {
+			t.Errorf("%s: %d points, want at most %d", tt.name, len(res.Points), maxHistoryPoints)
+		}
+	}
+}
+
+func TestGetStatisticsHistoryOfOwner(t *testing.T) {
+	s := newServer()
+	s.tenants[defaultTenant].queues[defaultQueue].paused = true
+	for _, name := range []string{"alice", "bob", "bob"} {
+		if _, err := s.SubmitTask(as(name, defaultTenant, roleSubmitter), &pb.TaskRequest{TaskDescription: "test", Priority: "LOW"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		want int32
+	}{
+		{"owner", as("alice", defaultTenant, roleSubmitter), 1},
+		{"other owner", as("bob", defaultTenant, roleSubmitter), 2},
+		{"viewer of every task", as("carol", defaultTenant, roleAuditor), 3},
+		{"caller without tasks", as("dave", defaultTenant, roleSubmitter), 0},
+	} {
+		res, err := s.GetStatisticsHistory(tt.ctx, &pb.StatisticsHistoryRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var submitted int32
+		for _, p := range res.Points {
+			submitted += p.ByPriority["LOW"].Submitted
+		}
+		if submitted != tt.want {
+			t.Errorf("%s: %d tasks submitted, want %d", tt.name, submitted, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Limits on the labels attached to a task.
+const (
+	maxLabels      = 32
+	maxLabelLength = 63
+)
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]*[a-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+)
+
+// validateLabels checks the labels of a task against the key/value rules and
+// the size limits.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("too many labels: %d, at most %d are allowed", len(labels), maxLabels)
+	}
+	for key, value := range labels {
+		if len(key) > maxLabelLength || !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if len(value) > maxLabelLength || !labelValuePattern.MatchString(value) {
+			return fmt.Errorf("invalid value %q for label %q", value, key)
+		}
+	}
+	return nil
+}
+
+// indexLabels adds the task to the label index of its tenant. The caller must
+// hold s.mu.
+func (s *server) indexLabels(t *task) {
+	index := s.tenants[t.tenant].labelIndex
+	for key, value := range t.labels {
+		values, ok := index[key]
+		if !ok {
+			values = make(map[string]map[string]struct{})
+			index[key] = values
+		}
+		ids, ok := values[value]
+		if !ok {
+			ids = make(map[string]struct{})
+			values[value] = ids
+		}
+		ids[t.id] = struct{}{}
+	}
+}
+
+// unindexLabels removes the task from the label index of its tenant. The
+// caller must hold s.mu.
+func (s *server) unindexLabels(t *task) {
+	index := s.tenants[t.tenant].labelIndex
+	for key, value := range t.labels {
+		ids := index[key][value]
+		delete(ids, t.id)
+		if len(ids) == 0 {
+			delete(index[key], value)
+		}
+		if len(index[key]) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+// tasksWithLabels returns the IDs of the tasks of the tenant indexed under the
+// most selective of the given label pairs. Every task carrying all the labels
+// is among them, but the result may contain tasks that only carry some.
+// The caller must hold the server lock.
+func (tn *tenant) tasksWithLabels(labels map[string]string) map[string]struct{} {
+	var smallest map[string]struct{}
+	first := true
+	for key, value := range labels {
+		ids := tn.labelIndex[key][value]
+		if first || len(ids) < len(smallest) {
+			smallest = ids
+			first = false
+		}
+	}
+	return smallest
+}
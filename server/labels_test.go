@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+)
+
+func TestValidateLabels(t *testing.T) {
+	many := func(n int) map[string]string {
+		labels := make(map[string]string)
+		for i := range n {
+			labels[fmt.Sprintf("key%d", i)] = "value"
+		}
+		return labels
+	}
+	long := strings.Repeat("a", maxLabelLength)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		valid  bool
+	}{
+		{"none", nil, true},
+		{"32 labels", many(maxLabels), true},
+		{"33 labels", many(maxLabels + 1), false},
+		{"63 character key", map[string]string{long: "x"}, true},
+		{"64 character key", map[string]string{long + "a": "x"}, false},
+		{"63 character value", map[string]string{"team": long}, true},
+		{"64 character value", map[string]string{"team": long + "a"}, false},
+		{"key with prefix", map[string]string{"example.com/team": "payments"}, true},
+		{"empty value", map[string]string{"team": ""}, true},
+		{"empty key", map[string]string{"": "x"}, false},
+		{"upper case key", map[string]string{"Team": "x"}, false},
+		{"key ending in a dash", map[string]string{"team-": "x"}, false},
+		{"value with a space", map[string]string{"team": "a b"}, false},
+		{"value starting with a dot", map[string]string{"team": ".a"}, false},
+	}
+	for _, tt := range tests {
+		if err := validateLabels(tt.labels); (err == nil) != tt.valid {
+			t.Errorf("%s: validateLabels returned %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestLabelQueries(t *testing.T) {
+	s := newServer()
+	s.tenants["acme"] = newTenant("acme", tenantConfig{})
+	for _, tn := range s.tenants {
+		tn.queues[defaultQueue].paused = true
+	}
+	submitAs := func(tenant string, labels map[string]string) string {
+		t.Helper()
+		res, err := s.SubmitTask(as("root", tenant, roleAdmin), &pb.TaskRequest{TaskDescription: "test", Labels: labels})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.TaskId
+	}
+	submitAs(defaultTenant, map[string]string{"team": "a", "env": "prod"})
+	submitAs(defaultTenant, map[string]string{"team": "a", "env": "dev"})
+	relabelled := submitAs(defaultTenant, map[string]string{"team": "b", "env": "prod"})
+	submitAs(defaultTenant, nil)
+	// The other tenant uses the same labels.
+	submitAs("acme", map[string]string{"team": "a", "env": "prod"})
+	submitAs("acme", map[string]string{"team": "c"})
+
+	if _, err := s.UpdateTask(as("root", defaultTenant, roleAdmin), &pb.UpdateTaskRequest{TaskId: relabelled, Labels: map[string]string{"team": "a", "env": "prod"}, UpdateMask: mask(updateLabels)}); err != nil {
+		t.Fatal(err)
+	}
+
+	matched := func(tenant string, labels map[string]string) int32 {
+		t.Helper()
+		stream := &bulkStream{ctx: as("root", tenant, roleAdmin)}
+		req := &pb.BulkOperationRequest{Action: bulkCancel, DryRun: true, Filter: &pb.TaskFilter{Labels: labels}}
+		if err := s.BulkOperation(req, stream); err != nil {
+			t.Fatal(err)
+		}
+		return stream.sent[len(stream.sent)-1].Matched
+	}
+	filters := []struct {
+		tenant string
+		labels map[string]string
+		want   int32
+	}{
+		{defaultTenant, map[string]string{"team": "a"}, 3},
+		{defaultTenant, map[string]string{"team": "a", "env": "prod"}, 2},
+		{defaultTenant, map[string]string{"env": "dev"}, 1},
+		{defaultTenant, map[string]string{"team": "b"}, 0},
+		{defaultTenant, map[string]string{"team": "c"}, 0},
+		{defaultTenant, map[string]string{"team": "a", "env": "staging"}, 0},
+		{"acme", map[string]string{"team": "a"}, 1},
+		{"acme", map[string]string{"team": "c"}, 1},
+	}
+	for _, tt := range filters {
+		if got := matched(tt.tenant, tt.labels); got != tt.want {
+			t.Errorf("tasks of %s matching %v: %d, want %d", tt.tenant, tt.labels, got, tt.want)
+		}
+	}
+
+	// Statistics grouped by label only count and name the values used
+	// within the tenant.
+	groups := map[string]map[string]int32{
+		defaultTenant: {"a": 3},
+		"acme":        {"a": 1, "c": 1},
+	}
+	for tenant, want := range groups {
+		stats, err := s.GetStatistics(as("root", tenant, roleAdmin), &pb.StatisticsRequest{GroupByLabel: "team"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats.ByLabel) != len(want) {
+			t.Errorf("%s: statistics by team %v, want %v", tenant, stats.ByLabel, want)
+		}
+		for value, n := range want {
+			if got := stats.ByLabel[value].GetQueued(); got != n {
+				t.Errorf("%s: %d tasks queued with team=%s, want %d", tenant, got, value, n)
+			}
+		}
+	}
+	if _, ok := s.tenants[defaultTenant].labelIndex["team"]["b"]; ok {
+		t.Error("label index still holds the replaced label team=b")
+	}
+}
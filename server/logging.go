@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/maciekb2/task-manager/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// logLevel is the level of the server logger. It is changed when the
+// configuration is reloaded.
+var logLevel = new(slog.LevelVar)
+
+// logConfig sets how the server logs.
+type logConfig struct {
+	// Format is "text" or "json".
+	Format string `yaml:"format"`
+	// Level is the lowest level logged: "debug", "info", "warn" or "error".
+	Level string `yaml:"level"`
+}
+
+// validate checks that the format and level are known.
+func (c logConfig) validate() error {
+	var errs []error
+	if c.Format != logging.FormatText && c.Format != logging.FormatJSON {
+		errs = append(errs, fmt.Errorf("invalid log format %q", c.Format))
+	}
+	if _, err := logging.ParseLevel(c.Level); err != nil {
+		errs = append(errs, fmt.Errorf("invalid log level %q", c.Level))
+	}
+	return errors.Join(errs...)
+}
+
+// setupLogging makes a logger with the configuration the default one.
+func setupLogging(c logConfig) error {
+	level, err := logging.ParseLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+	logger, err := logging.New(os.Stderr, c.Format, logLevel)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// callLevel returns the level calls ending with the code are logged at:
+// errors of the server are logged as errors, errors of the caller as
+// warnings.
+func callLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented:
+		return slog.LevelError
+	}
+	return slog.LevelWarn
+}
+
+// logCall logs a finished call with its duration and status code.
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	slog.LogAttrs(ctx, callLevel(code), "call finished", attrs...)
+}
+
+// loggingUnaryInterceptor gives every unary call its own log attributes,
+// including the task it is about, and logs the call when it ends. It runs
+// before authentication, which adds the principal.
+func loggingUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx = logging.NewContext(ctx)
+	taskID := taskIDOf(req)
+	if taskID != "" {
+		logging.With(ctx, slog.String("task_id", taskID))
+	}
+	resp, err := handler(ctx, req)
+	// New tasks only get their ID in the response.
+	if id := taskIDOf(resp); taskID == "" && id != "" {
+		logging.With(ctx, slog.String("task_id", id))
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// loggingStreamInterceptor gives every streaming call its own log attributes
+// and logs the call when it ends.
+func loggingStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ls := &loggingStream{ServerStream: stream, ctx: logging.NewContext(stream.Context())}
+	err := handler(srv, ls)
+	logCall(ls.ctx, info.FullMethod, start, err)
+	return err
+}
+
+// loggingStream is a server stream with its own log attributes. The task of
+// a received request is added to them.
+type loggingStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggingStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *loggingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if id := taskIDOf(m); err == nil && id != "" {
+		logging.With(s.ctx, slog.String("task_id", id))
+	}
+	return err
+}
+
+// taskIDOf returns the task ID of a request or response, if it has one.
+func taskIDOf(msg any) string {
+	if m, ok := msg.(interface{ GetTaskId() string }); ok {
+		return m.GetTaskId()
+	}
+	return ""
+}
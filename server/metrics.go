@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// durationBuckets are the histogram buckets, in seconds, for how long tasks
+// wait and run.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+// Kinds of retries counted by the retries metric.
+const (
+	retryAutomatic = "automatic"
+	retryManual    = "manual"
+)
+
+// taskMetrics holds the instruments recording the task lifecycle.
+type taskMetrics struct {
+	submitted metric.Int64Counter
+	finished  metric.Int64Counter
+	retries   metric.Int64Counter
+	queueWait metric.Float64Histogram
+	duration  metric.Float64Histogram
+	streams   metric.Int64UpDownCounter
+}
+
+// newTaskMetrics creates the task lifecycle instruments.
+func newTaskMetrics(meter metric.Meter) (taskMetrics, error) {
+	var m taskMetrics
+	var err error
+	if m.submitted, err = meter.Int64Counter("taskmanager.tasks.submitted",
+		metric.WithDescription("Number of submitted tasks, by tenant, queue and priority.")); err != nil {
+		return m, err
+	}
+	if m.finished, err = meter.Int64Counter("taskmanager.tasks.finished",
+		metric.WithDescription("Number of tasks that reached COMPLETED, FAILED or CANCELLED, by tenant, queue, priority and status.")); err != nil {
+		return m, err
+	}
+	if m.retries, err = meter.Int64Counter("taskmanager.tasks.retries",
+		metric.WithDescription("Number of retried tasks, by tenant, queue and kind: automatic under the queue retry policy or manual.")); err != nil {
+		return m, err
+	}
+	if m.queueWait, err = meter.Float64Histogram("taskmanager.task.queue_wait",
+		metric.WithDescription("Time tasks spent QUEUED before being started."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		return m, err
+	}
+	if m.duration, err = meter.Float64Histogram("taskmanager.task.duration",
+		metric.WithDescription("Time tasks spent IN_PROGRESS, by the status they moved to."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		return m, err
+	}
+	if m.streams, err = meter.Int64UpDownCounter("taskmanager.streams.active",
+		metric.WithDescription("Number of open StreamTaskStatus streams.")); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// noopTaskMetrics returns instruments that record nothing, used until the
+// metrics are registered.
+func noopTaskMetrics() taskMetrics {
+	// The noop meter never fails.
+	m, _ := newTaskMetrics(noop.Meter{})
+	return m
+}
+
+// taskAttributes returns the attributes identifying the tenant, queue and
+// priority of a task.
+func taskAttributes(t *task, extra ...attribute.KeyValue) metric.MeasurementOption {
+	return metric.WithAttributes(append([]attribute.KeyValue{
+		attribute.String("tenant", t.tenant),
+		attribute.String("queue", t.queue),
+		attribute.String("priority", t.priority),
+	}, extra...)...)
+}
+
+// recordTransition records the metrics of a task moving to a new status.
+// The caller must hold s.mu.
+func (s *server) recordTransition(t *task, status string, now time.Time) {
+	ctx := context.Background()
+	if t.status == statusInProgress && status != statusInProgress {
+		s.metrics.duration.Record(ctx, now.Sub(t.startedAt).Seconds(), taskAttributes(t, attribute.String("status", status)))
+	}
+	if isTerminal(status) {
+		s.metrics.finished.Add(ctx, 1, taskAttributes(t, attribute.String("status", status)))
+	}
+}
+
+// recordRetry counts a retry of the task.
+func (s *server) recordRetry(t *task, kind string) {
+	s.metrics.retries.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("tenant", t.tenant),
+		attribute.String("queue", t.queue),
+		attribute.String("kind", kind),
+	))
+}
+
+// registerTaskMetrics records the task lifecycle through the meter and
+// reports the depth of every queue.
+func (s *server) registerTaskMetrics(meter metric.Meter) error {
+	m, err := newTaskMetrics(meter)
+	if err != nil {
+		return err
+	}
+	s.metrics = m
+
+	depth, err := meter.Int64ObservableGauge("taskmanager.queue.depth",
+		metric.WithDescription("Number of tasks waiting in a queue."))
+	if err != nil {
+		return err
+	}
+	running, err := meter.Int64ObservableGauge("taskmanager.queue.running",
+		metric.WithDescription("Number of running tasks of a queue."))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for _, tn := range s.tenants {
+			for _, q := range tn.queues {
+				attrs := metric.WithAttributes(
+					attribute.String("tenant", tn.name),
+					attribute.String("queue", q.name),
+				)
+				o.ObserveInt64(depth, int64(q.pending.Len()), attrs)
+				o.ObserveInt64(running, int64(q.running), attrs)
+			}
+		}
+		return nil
+	}, depth, running)
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"go.opentelemetry.io/otel/attribute"
+	metricsdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// collectMetrics returns the data points of every metric read by the reader,
+// by metric name and then by the encoded attributes of the point. Histograms
+// are reported by their count.
+func collectMetrics(t *testing.T, reader metricsdk.Reader) map[string]map[string]float64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]map[string]float64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			points := make(map[string]float64)
+			key := func(attrs attribute.Set) string { return attrs.Encoded(attribute.DefaultEncoder()) }
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, p := range data.DataPoints {
+					points[key(p.Attributes)] = float64(p.Value)
+				}
+			case metricdata.Gauge[int64]:
+				for _, p := range data.DataPoints {
+					points[key(p.Attributes)] = float64(p.Value)
+				}
+			case metricdata.Histogram[float64]:
+				for _, p := range data.DataPoints {
+					points[key(p.Attributes)] = float64(p.Count)
+				}
+			}
+			res[m.Name] = points
+		}
+	}
+	return res
+}
+
+// waitForStatus waits until the task reaches the status.
+func waitForStatus(t *testing.T, s *server, task *task, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for taskStatus(s, task) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("task is %s, want %s", taskStatus(s, task), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTaskMetrics(t *testing.T) {
+	s := newServer()
+	reader := metricsdk.NewManualReader()
+	meter := metricsdk.NewMeterProvider(metricsdk.WithReader(reader)).Meter("test")
+	if err := s.registerTaskMetrics(meter); err != nil {
+		t.Fatal(err)
+	}
+	// The first attempt fails, every later one completes.
+	var attempts atomic.Int32
+	s.work = func(ctx context.Context, t *task) string {
+		if attempts.Add(1) == 1 {
+			return statusFailed
+		}
+		return statusCompleted
+	}
+	if _, err := s.CreateQueue(context.Background(), &pb.CreateQueueRequest{Queue: &pb.Queue{
+		Name:        "batch",
+		RetryPolicy: &pb.RetryPolicy{MaxRetries: 1, Backoff: durationpb.New(0)},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	s.tenants[defaultTenant].queues[defaultQueue].paused = true
+
+	// A task that is retried automatically and completes.
+	retried := submit(t, s, "batch", "HIGH")
+	waitForStatus(t, s, retried, statusCompleted)
+	// A task that is cancelled while queued, then retried by hand.
+	cancelled := submit(t, s, "", "LOW")
+	for _, action := range []string{bulkCancel, bulkRetry} {
+		req := &pb.BulkOperationRequest{Action: action, Filter: &pb.TaskFilter{Priorities: []string{"LOW"}}}
+		if err := s.BulkOperation(req, &bulkStream{ctx: context.Background()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := taskStatus(s, cancelled); got != statusQueued {
+		t.Fatalf("task retried by hand is %s, want %s", got, statusQueued)
+	}
+
+	want := map[string]map[string]float64{
+		"taskmanager.tasks.submitted": {
+			"priority=HIGH,queue=batch,tenant=default":  1,
+			"priority=LOW,queue=default,tenant=default": 1,
+		},
+		"taskmanager.tasks.finished": {
+			"priority=HIGH,queue=batch,status=COMPLETED,tenant=default":  1,
+			"priority=LOW,queue=default,status=CANCELLED,tenant=default": 1,
+		},
+		"taskmanager.tasks.retries": {
+			"kind=automatic,queue=batch,tenant=default": 1,
+			"kind=manual,queue=default,tenant=default":  1,
+		},
+		"taskmanager.task.queue_wait": {
+			"priority=HIGH,queue=batch,tenant=default": 2,
+		},
+		// The failed attempt ran until the task was queued again.
+		"taskmanager.task.duration": {
+			"priority=HIGH,queue=batch,status=QUEUED,tenant=default":    1,
+			"priority=HIGH,queue=batch,status=COMPLETED,tenant=default": 1,
+		},
+		"taskmanager.queue.depth": {
+			"queue=batch,tenant=default":   0,
+			"queue=default,tenant=default": 1,
+		},
+		"taskmanager.queue.running": {
+			"queue=batch,tenant=default":   0,
+			"queue=default,tenant=default": 0,
+		},
+	}
+	got := collectMetrics(t, reader)
+	for name, points := range want {
+		// Every point is one of the expected attribute sets, so no
+		// attribute such as the task ID multiplies the series.
+		if !maps.Equal(got[name], points) {
+			t.Errorf("%s = %v, want %v", name, got[name], points)
+		}
+	}
+	if names := slices.Sorted(maps.Keys(got)); len(names) != len(want) {
+		t.Errorf("metrics %v, want %d metrics", names, len(want))
+	}
+}
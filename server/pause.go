@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	pb "github.com/maciekb2/task-manager/proto"
+)
+
+// PauseProcessing stops starting tasks on the whole server. SubmitTask keeps
+// accepting tasks and running tasks are allowed to finish. Pausing the whole
+// server affects every tenant and needs the operator role. Naming a queue does
+// the same as PauseQueue, which is the RPC meant for single queues.
+func (s *server) PauseProcessing(ctx context.Context, req *pb.ProcessingRequest) (*pb.ProcessingState, error) {
+	if req.Queue == "" {
+		if err := checkPermission(ctx, permTenants, "pause or resume the whole server"); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tn, err := s.callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Queue == "" {
+		s.paused = true
+		return s.processingState(tn), nil
+	}
+	q, err := tn.queueByName(req.Queue)
+	if err != nil {
+		return nil, err
+	}
+	s.pauseQueue(q)
+	return s.processingState(tn), nil
+}
+
+// ResumeProcessing starts tasks again on the whole server. Resuming the server
+// leaves paused queues paused and, like pausing it, needs the operator role.
+// Naming a queue does the same as ResumeQueue, which is the RPC meant for
+// single queues.
+func (s *server) ResumeProcessing(ctx context.Context, req *pb.ProcessingRequest) (*pb.ProcessingState, error) {
+	if req.Queue == "" {
+		if err := checkPermission(ctx, permTenants, "pause or resume the whole server"); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tn, err := s.callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Queue == "" {
+		s.paused = false
+		for _, tn := range s.tenants {
+			s.dispatchTenant(tn)
+		}
+		return s.processingState(tn), nil
+	}
+	q, err := tn.queueByName(req.Queue)
+	if err != nil {
+		return nil, err
+	}
+	s.resumeQueue(q)
+	return s.processingState(tn), nil
+}
+
+// processingState reports what is paused for the tenant. The caller must hold
+// s.mu.
+func (s *server) processingState(tn *tenant) *pb.ProcessingState {
+	return &pb.ProcessingState{Paused: s.paused, PausedQueues: tn.pausedQueues()}
+}
+
+// pausedQueues returns the names of the paused queues of the tenant, sorted.
+// The caller must hold the server lock.
+func (tn *tenant) pausedQueues() []string {
+	var names []string
+	for name, q := range tn.queues {
+		if q.paused {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPauseProcessingServerWide(t *testing.T) {
+	s := newServer()
+	release := make(chan struct{})
+	defer close(release)
+	s.work = blockingWork(release)
+	s.tenants["acme"] = newTenant("acme", tenantConfig{})
+	admin := as("root", defaultTenant, roleAdmin)
+	operator := as("ops", defaultTenant, roleOperator)
+
+	// Only operators may pause or resume every tenant.
+	if _, err := s.PauseProcessing(admin, &pb.ProcessingRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("PauseProcessing as admin returned %v, want %v", err, codes.PermissionDenied)
+	}
+	state, err := s.PauseProcessing(operator, &pb.ProcessingRequest{})
+	if err != nil {
+		t.Fatalf("PauseProcessing as operator: %v", err)
+	}
+	if !state.Paused {
+		t.Errorf("state after pausing %v, want paused", state)
+	}
+
+	// Tasks of every tenant are accepted but not started.
+	mine, err := s.SubmitTask(as("alice", defaultTenant, roleSubmitter), &pb.TaskRequest{TaskDescription: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := s.SubmitTask(as("bob", "acme", roleSubmitter), &pb.TaskRequest{TaskDescription: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{mine.TaskId, theirs.TaskId} {
+		if got := taskStatus(s, s.tasks[id]); got != statusQueued {
+			t.Errorf("task submitted while paused is %s, want %s", got, statusQueued)
+		}
+	}
+
+	// A queue paused on its own stays paused when the server resumes.
+	if _, err := s.PauseQueue(as("root", "acme", roleAdmin), &pb.QueueRequest{Name: defaultQueue}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ResumeProcessing(admin, &pb.ProcessingRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ResumeProcessing as admin returned %v, want %v", err, codes.PermissionDenied)
+	}
+	if state, err = s.ResumeProcessing(operator, &pb.ProcessingRequest{}); err != nil {
+		t.Fatalf("ResumeProcessing as operator: %v", err)
+	}
+	if state.Paused {
+		t.Errorf("state after resuming %v, want not paused", state)
+	}
+	if got := taskStatus(s, s.tasks[mine.TaskId]); got != statusInProgress {
+		t.Errorf("task is %s after resuming, want %s", got, statusInProgress)
+	}
+	if got := taskStatus(s, s.tasks[theirs.TaskId]); got != statusQueued {
+		t.Errorf("task of a paused queue is %s after resuming the server, want %s", got, statusQueued)
+	}
+}
+
+func TestPauseProcessingQueueMatchesPauseQueue(t *testing.T) {
+	s := newServer()
+	admin := as("root", defaultTenant, roleAdmin)
+	q := s.tenants[defaultTenant].queues[defaultQueue]
+
+	// Naming a queue needs no operator role and leaves the server running.
+	state, err := s.PauseProcessing(admin, &pb.ProcessingRequest{Queue: defaultQueue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Paused || !slices.Equal(state.PausedQueues, []string{defaultQueue}) || !q.paused {
+		t.Errorf("state after pausing the queue %v, want only %s paused", state, defaultQueue)
+	}
+
+	// Resuming reopens a draining queue, as ResumeQueue does.
+	if _, err := s.DrainQueue(admin, &pb.QueueRequest{Name: defaultQueue}); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = s.ResumeProcessing(admin, &pb.ProcessingRequest{Queue: defaultQueue}); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.PausedQueues) != 0 || q.paused || q.draining {
+		t.Errorf("queue after resuming is paused %t and draining %t, want neither", q.paused, q.draining)
+	}
+
+	if _, err := s.PauseProcessing(admin, &pb.ProcessingRequest{Queue: "nightly"}); status.Code(err) != codes.NotFound {
+		t.Errorf("pausing an unknown queue returned %v, want %v", err, codes.NotFound)
+	}
+}
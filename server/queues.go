@@ -0,0 +1,402 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maciekb2/task-manager/logging"
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// defaultQueue is the queue used by tasks submitted without one. It always
+// exists.
+const defaultQueue = "default"
+
+// defaultPriority is the priority of tasks submitted without one to a queue
+// that does not configure its own.
+const defaultPriority = "MEDIUM"
+
+var queueNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// queueConfig holds the settings of a queue that can be changed by admins.
+type queueConfig struct {
+	// maxConcurrency limits the number of running tasks; 0 means unlimited.
+	maxConcurrency  int
+	defaultPriority string
+	maxRetries      int
+	retryBackoff    time.Duration
+}
+
+// queue is a named queue of tasks with its own configuration. Queues belong
+// to a tenant and their names are only unique within it.
+type queue struct {
+	tenant   *tenant
+	name     string
+	config   queueConfig
+	paused   bool
+	draining bool
+	pending  taskHeap
+	running  int
+}
+
+// newQueue creates an empty queue of the tenant.
+func newQueue(tn *tenant, name string, config queueConfig) *queue {
+	if config.defaultPriority == "" {
+		config.defaultPriority = defaultPriority
+	}
+	return &queue{tenant: tn, name: name, config: config}
+}
+
+// hasCapacity reports whether another task of the queue may be started.
+func (q *queue) hasCapacity() bool {
+	return q.config.maxConcurrency <= 0 || q.running < q.config.maxConcurrency
+}
+
+// proto converts the queue for the API. The caller must hold the server lock.
+func (q *queue) proto() *pb.Queue {
+	return &pb.Queue{
+		Name:            q.name,
+		MaxConcurrency:  int32(q.config.maxConcurrency),
+		DefaultPriority: q.config.defaultPriority,
+		RetryPolicy: &pb.RetryPolicy{
+			MaxRetries: int32(q.config.maxRetries),
+			Backoff:    durationpb.New(q.config.retryBackoff),
+		},
+		Paused:     q.paused,
+		Draining:   q.draining,
+		Queued:     int32(q.pending.Len()),
+		InProgress: int32(q.running),
+	}
+}
+
+// priorityRank orders priorities from most to least urgent.
+func priorityRank(priority string) int {
+	switch priority {
+	case "HIGH":
+		return 0
+	case "MEDIUM":
+		return 1
+	case "LOW":
+		return 2
+	}
+	return 3
+}
+
+// taskHeap orders the waiting tasks of a queue by priority and then by the
+// time they were queued. It implements heap.Interface.
+type taskHeap []*task
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool {
+	ri, rj := priorityRank(h[i].priority), priorityRank(h[j].priority)
+	if ri != rj {
+		return ri < rj
+	}
+	return h[i].queuedAt.Before(h[j].queuedAt)
+}
+
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *taskHeap) Push(x any) {
+	t := x.(*task)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// queueOf returns the queue of the task. The caller must hold s.mu.
+func (s *server) queueOf(t *task) *queue {
+	return s.tenants[t.tenant].queues[t.queue]
+}
+
+// enqueue adds a QUEUED task to its queue and starts it if there is room.
+// The caller must hold s.mu.
+func (s *server) enqueue(t *task) {
+	q := s.queueOf(t)
+	t.queuedAt = time.Now()
+	heap.Push(&q.pending, t)
+	s.dispatch(q)
+}
+
+// dequeue removes a task from the waiting tasks of its queue, if it is there.
+// The caller must hold s.mu.
+func (s *server) dequeue(t *task) {
+	if t.index >= 0 {
+		heap.Remove(&s.queueOf(t).pending, t.index)
+	}
+}
+
+// setPriority changes the priority of a task, moving it to its new position
+// if it is waiting in a queue. The caller must hold s.mu.
+func (s *server) setPriority(t *task, priority string) {
+	s.countStats(t, -1)
+	t.priority = priority
+	s.countStats(t, 1)
+	if t.index >= 0 {
+		heap.Fix(&s.queueOf(t).pending, t.index)
+	}
+}
+
+// setQueue moves a task to another queue of its tenant. A task waiting in its
+// queue keeps its place among the tasks of its priority in the new one; a task
+// waiting for a retry joins the new queue once its backoff has passed. The
+// caller must hold s.mu.
+func (s *server) setQueue(t *task, q *queue) {
+	waiting := t.index >= 0
+	s.dequeue(t)
+	s.countStats(t, -1)
+	t.queue = q.name
+	s.countStats(t, 1)
+	if waiting {
+		heap.Push(&q.pending, t)
+		s.dispatch(q)
+	}
+}
+
+// dispatch starts waiting tasks of the queue, most urgent first, until the
+// queue or its tenant is out of capacity. Nothing is started while the queue
+// or the whole server is paused, or once the server is shutting down. The
+// caller must hold s.mu.
+func (s *server) dispatch(q *queue) {
+	for !s.shuttingDown && !s.paused && !q.paused && q.pending.Len() > 0 && q.hasCapacity() && q.tenant.hasCapacity() {
+		t := heap.Pop(&q.pending).(*task)
+		t.startedAt = time.Now()
+		s.metrics.queueWait.Record(context.Background(), t.startedAt.Sub(t.queuedAt).Seconds(), taskAttributes(t))
+		ctx, cancel := context.WithCancel(s.startExecution(t))
+		ctx = logging.NewContext(ctx, slog.String("task_id", t.id))
+		if t.owner != "" {
+			logging.With(ctx, slog.String("principal", t.owner))
+		}
+		t.cancel = cancel
+		q.running++
+		q.tenant.running++
+		s.inFlight.Add(1)
+		// Update status to IN_PROGRESS.
+		s.setStatus(t, statusInProgress)
+		go s.processTask(ctx, t, t.attempt)
+	}
+}
+
+// scheduleRetry queues a failed task again after the retry backoff of its
+// queue. The caller must hold s.mu.
+func (s *server) scheduleRetry(t *task, q *queue) {
+	t.retries++
+	t.attempt++
+	s.recordRetry(t, retryAutomatic)
+	t.record("retry %d of %d in %s", t.retries, q.config.maxRetries, q.config.retryBackoff)
+	s.setStatus(t, statusQueued)
+
+	attempt := t.attempt
+	time.AfterFunc(q.config.retryBackoff, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.tasks[t.id] == t && t.attempt == attempt && t.status == statusQueued && t.index < 0 {
+			s.enqueue(t)
+		}
+	})
+}
+
+// dispatchTenant starts waiting tasks of every queue of the tenant.
+// The caller must hold s.mu.
+func (s *server) dispatchTenant(tn *tenant) {
+	for _, q := range tn.queues {
+		s.dispatch(q)
+	}
+}
+
+// callerQueue returns the named queue of the tenant of the caller.
+// The caller must hold s.mu.
+func (s *server) callerQueue(ctx context.Context, name string) (*queue, error) {
+	tn, err := s.callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tn.queueByName(name)
+}
+
+// queueConfigFromProto validates the configuration part of a queue received
+// over the API.
+func queueConfigFromProto(q *pb.Queue) (queueConfig, error) {
+	config := queueConfig{
+		maxConcurrency:  int(q.GetMaxConcurrency()),
+		defaultPriority: q.GetDefaultPriority(),
+		maxRetries:      int(q.GetRetryPolicy().GetMaxRetries()),
+		retryBackoff:    q.GetRetryPolicy().GetBackoff().AsDuration(),
+	}
+	if config.maxConcurrency < 0 {
+		return config, status.Error(codes.InvalidArgument, "max_concurrency must not be negative")
+	}
+	if config.defaultPriority != "" && !validPriority(config.defaultPriority) {
+		return config, status.Errorf(codes.InvalidArgument, "invalid default priority %q", config.defaultPriority)
+	}
+	if config.maxRetries < 0 {
+		return config, status.Error(codes.InvalidArgument, "max_retries must not be negative")
+	}
+	if config.retryBackoff < 0 {
+		return config, status.Error(codes.InvalidArgument, "backoff must not be negative")
+	}
+	return config, nil
+}
+
+// CreateQueue creates a new named queue in the tenant of the caller.
+func (s *server) CreateQueue(ctx context.Context, req *pb.CreateQueueRequest) (*pb.Queue, error) {
+	name := req.GetQueue().GetName()
+	if !queueNamePattern.MatchString(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid queue name %q", name)
+	}
+	config, err := queueConfigFromProto(req.GetQueue())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tn, err := s.callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := tn.queues[name]; exists {
+		return nil, status.Errorf(codes.AlreadyExists, "queue %q already exists", name)
+	}
+	q := newQueue(tn, name, config)
+	tn.queues[name] = q
+	return q.proto(), nil
+}
+
+// ListQueues returns the queues of the tenant of the caller sorted by name.
+func (s *server) ListQueues(ctx context.Context, req *pb.ListQueuesRequest) (*pb.ListQueuesResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tn, err := s.callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.ListQueuesResponse{}
+	for _, q := range tn.queues {
+		res.Queues = append(res.Queues, q.proto())
+	}
+	slices.SortFunc(res.Queues, func(a, b *pb.Queue) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res, nil
+}
+
+// UpdateQueue changes the configuration fields of a queue named in the
+// update mask.
+func (s *server) UpdateQueue(ctx context.Context, req *pb.UpdateQueueRequest) (*pb.Queue, error) {
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "update_mask must name at least one field")
+	}
+	config, err := queueConfigFromProto(req.GetQueue())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.callerQueue(ctx, req.GetQueue().GetName())
+	if err != nil {
+		return nil, err
+	}
+	updated := q.config
+	for _, path := range paths {
+		switch path {
+		case "max_concurrency":
+			updated.maxConcurrency = config.maxConcurrency
+		case "default_priority":
+			updated.defaultPriority = config.defaultPriority
+			if updated.defaultPriority == "" {
+				updated.defaultPriority = defaultPriority
+			}
+		case "retry_policy":
+			updated.maxRetries = config.maxRetries
+			updated.retryBackoff = config.retryBackoff
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "field %q cannot be updated", path)
+		}
+	}
+	q.config = updated
+	s.dispatch(q)
+	return q.proto(), nil
+}
+
+// PauseQueue stops starting tasks from a queue. Tasks can still be submitted
+// and running tasks finish.
+func (s *server) PauseQueue(ctx context.Context, req *pb.QueueRequest) (*pb.Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.callerQueue(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	s.pauseQueue(q)
+	return q.proto(), nil
+}
+
+// ResumeQueue starts tasks from a paused queue again and lets a draining
+// queue accept new tasks.
+func (s *server) ResumeQueue(ctx context.Context, req *pb.QueueRequest) (*pb.Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.callerQueue(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	s.resumeQueue(q)
+	return q.proto(), nil
+}
+
+// pauseQueue stops starting tasks from the queue. The caller must hold s.mu.
+func (s *server) pauseQueue(q *queue) {
+	q.paused = true
+}
+
+// resumeQueue starts tasks from the queue again and reopens it if it was
+// draining. The caller must hold s.mu.
+func (s *server) resumeQueue(q *queue) {
+	q.paused = false
+	q.draining = false
+	s.dispatch(q)
+}
+
+// DrainQueue stops a queue from accepting new tasks. Tasks already in the
+// queue are still processed.
+func (s *server) DrainQueue(ctx context.Context, req *pb.QueueRequest) (*pb.Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, err := s.callerQueue(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	q.draining = true
+	return q.proto(), nil
+}
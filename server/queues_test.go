@@ -0,0 +1,253 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// submit submits a task to the queue of the default tenant and returns it.
+func submit(t *testing.T, s *server, queue, priority string) *task {
+	t.Helper()
+	res, err := s.SubmitTask(context.Background(), &pb.TaskRequest{TaskDescription: "test", Priority: priority, Queue: queue})
+	if err != nil {
+		t.Fatalf("SubmitTask(%s, %s): %v", queue, priority, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tasks[res.TaskId]
+}
+
+// pendingOrder returns the IDs of the waiting tasks of the queue in the order
+// they would be started, leaving the queue as it was.
+func pendingOrder(s *server, q *queue) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := slices.Clone(q.pending)
+	var ids []string
+	for h.Len() > 0 {
+		ids = append(ids, heap.Pop(&h).(*task).id)
+	}
+	// Popping the copy moved the index of the shared tasks.
+	for i, t := range q.pending {
+		t.index = i
+	}
+	return ids
+}
+
+// taskStatus returns the status of the task under the server lock.
+func taskStatus(s *server, t *task) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return t.status
+}
+
+func TestTaskHeapOrder(t *testing.T) {
+	base := time.Now()
+	tasks := []*task{
+		{id: "low-1", priority: "LOW", queuedAt: base},
+		{id: "high-2", priority: "HIGH", queuedAt: base.Add(3 * time.Second)},
+		{id: "medium-1", priority: "MEDIUM", queuedAt: base.Add(time.Second)},
+		{id: "high-1", priority: "HIGH", queuedAt: base.Add(2 * time.Second)},
+		{id: "low-2", priority: "LOW", queuedAt: base.Add(4 * time.Second)},
+		{id: "medium-2", priority: "MEDIUM", queuedAt: base.Add(5 * time.Second)},
+	}
+	var h taskHeap
+	for _, task := range tasks {
+		heap.Push(&h, task)
+	}
+	for i, task := range h {
+		if task.index != i {
+			t.Errorf("task %s has index %d, want %d", task.id, task.index, i)
+		}
+	}
+
+	var got []string
+	for h.Len() > 0 {
+		task := heap.Pop(&h).(*task)
+		if task.index != -1 {
+			t.Errorf("popped task %s has index %d, want -1", task.id, task.index)
+		}
+		got = append(got, task.id)
+	}
+	want := []string{"high-1", "high-2", "medium-1", "medium-2", "low-1", "low-2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("tasks popped in order %v, want %v", got, want)
+	}
+}
+
+func TestDispatchOrder(t *testing.T) {
+	s := newServer()
+	q := s.tenants[defaultTenant].queues[defaultQueue]
+	q.paused = true
+
+	low := submit(t, s, "", "LOW")
+	medium1 := submit(t, s, "", "MEDIUM")
+	high := submit(t, s, "", "HIGH")
+	medium2 := submit(t, s, "", "MEDIUM")
+	medium3 := submit(t, s, "", "MEDIUM")
+	for _, task := range []*task{low, medium1, high, medium2, medium3} {
+		if got := taskStatus(s, task); got != statusQueued {
+			t.Fatalf("task submitted to a paused queue is %s, want %s", got, statusQueued)
+		}
+	}
+
+	// Raising a waiting task moves it ahead of the tasks queued before it.
+	s.mu.Lock()
+	s.setPriority(medium3, "HIGH")
+	s.mu.Unlock()
+	want := []string{high.id, medium3.id, medium1.id, medium2.id, low.id}
+	if got := pendingOrder(s, q); !slices.Equal(got, want) {
+		t.Fatalf("pending order %v, want %v", got, want)
+	}
+
+	// With room for two tasks, the two most urgent ones start.
+	s.mu.Lock()
+	q.config.maxConcurrency = 2
+	q.paused = false
+	s.dispatch(q)
+	s.mu.Unlock()
+	for _, task := range []*task{high, medium3} {
+		if got := taskStatus(s, task); got != statusInProgress {
+			t.Errorf("task %s is %s, want %s", task.priority, got, statusInProgress)
+		}
+	}
+	want = []string{medium1.id, medium2.id, low.id}
+	if got := pendingOrder(s, q); !slices.Equal(got, want) {
+		t.Errorf("pending order %v, want %v", got, want)
+	}
+}
+
+func TestPauseResumeDrain(t *testing.T) {
+	ctx := context.Background()
+	s := newServer()
+	q := s.tenants[defaultTenant].queues[defaultQueue]
+
+	if _, err := s.PauseQueue(ctx, &pb.QueueRequest{Name: defaultQueue}); err != nil {
+		t.Fatalf("PauseQueue: %v", err)
+	}
+	paused := submit(t, s, "", "HIGH")
+	if got := taskStatus(s, paused); got != statusQueued {
+		t.Fatalf("task submitted to a paused queue is %s, want %s", got, statusQueued)
+	}
+
+	res, err := s.DrainQueue(ctx, &pb.QueueRequest{Name: defaultQueue})
+	if err != nil {
+		t.Fatalf("DrainQueue: %v", err)
+	}
+	if !res.Paused || !res.Draining || res.Queued != 1 {
+		t.Errorf("DrainQueue = %v, want a paused, draining queue with 1 queued task", res)
+	}
+	_, err = s.SubmitTask(ctx, &pb.TaskRequest{TaskDescription: "test"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("SubmitTask to a draining queue returned %v, want %v", err, codes.FailedPrecondition)
+	}
+
+	// Resuming starts the waiting task and accepts new ones again.
+	res, err = s.ResumeQueue(ctx, &pb.QueueRequest{Name: defaultQueue})
+	if err != nil {
+		t.Fatalf("ResumeQueue: %v", err)
+	}
+	if res.Paused || res.Draining || res.Queued != 0 || res.InProgress != 1 {
+		t.Errorf("ResumeQueue = %v, want a running queue with 1 task in progress", res)
+	}
+	if got := taskStatus(s, paused); got != statusInProgress {
+		t.Errorf("task of a resumed queue is %s, want %s", got, statusInProgress)
+	}
+	if got := taskStatus(s, submit(t, s, "", "LOW")); got != statusInProgress {
+		t.Errorf("task submitted after resuming is %s, want %s", got, statusInProgress)
+	}
+
+	// Pausing the server holds back every queue, however the queue is set.
+	s.mu.Lock()
+	s.paused = true
+	s.mu.Unlock()
+	if got := taskStatus(s, submit(t, s, "", "HIGH")); got != statusQueued {
+		t.Errorf("task submitted to a paused server is %s, want %s", got, statusQueued)
+	}
+	if n := len(pendingOrder(s, q)); n != 1 {
+		t.Errorf("paused server has %d waiting tasks, want 1", n)
+	}
+}
+
+func TestRetryRacingCancel(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	s := newServer()
+	q := s.tenants[defaultTenant].queues[defaultQueue]
+	q.config.maxRetries = 3
+	q.config.retryBackoff = backoff
+	q.paused = true
+
+	tests := []struct {
+		name string
+		// race runs while the retry is waiting for its backoff.
+		race func(task *task)
+		want string
+		// queued is the number of times the task must be waiting in the
+		// queue once the backoff has passed.
+		queued int
+	}{{
+		name:   "no race",
+		race:   func(*task) {},
+		want:   statusQueued,
+		queued: 1,
+	}, {
+		name: "cancelled",
+		race: func(task *task) {
+			if !s.cancelTask(task) {
+				t.Errorf("cancelTask of a task waiting for a retry failed")
+			}
+		},
+		want:   statusCancelled,
+		queued: 0,
+	}, {
+		name: "cancelled and retried",
+		race: func(task *task) {
+			s.cancelTask(task)
+			if !s.retryTask(context.Background(), task) {
+				t.Errorf("retryTask of a cancelled task failed")
+			}
+		},
+		want:   statusQueued,
+		queued: 1,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := submit(t, s, "", "MEDIUM")
+
+			// Fail the task as processTask would.
+			s.mu.Lock()
+			s.dequeue(task)
+			s.setStatus(task, statusInProgress)
+			s.scheduleRetry(task, q)
+			if task.status != statusQueued || task.index != -1 {
+				t.Errorf("task waiting for a retry is %s at index %d, want %s outside the queue", task.status, task.index, statusQueued)
+			}
+			tt.race(task)
+			s.mu.Unlock()
+
+			time.Sleep(5 * backoff)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if task.status != tt.want {
+				t.Errorf("task is %s, want %s", task.status, tt.want)
+			}
+			queued := 0
+			for _, pending := range q.pending {
+				if pending == task {
+					queued++
+				}
+			}
+			if queued != tt.queued {
+				t.Errorf("task is in the queue %d times, want %d", queued, tt.queued)
+			}
+		})
+	}
+}
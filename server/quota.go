@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Reasons a submission is rejected, as reported by GetTenantUsage and the
+// metrics.
+const (
+	rejectRate        = "rate"
+	rejectCallerRate  = "caller_rate"
+	rejectMaxQueued   = "max_queued"
+	rejectMaxInFlight = "max_in_flight"
+)
+
+// callerSweepInterval is how often the token buckets of the callers of a
+// tenant are checked for ones that filled up again. Those are dropped, a full
+// bucket is no different from the new one made on the next submission.
+const callerSweepInterval = time.Minute
+
+// quotaConfig limits how fast and how many tasks a tenant may submit. Zero
+// values mean no limit.
+type quotaConfig struct {
+	// SubmitRate is the number of tasks per second the tenant may submit,
+	// with bursts of up to SubmitBurst tasks.
+	SubmitRate  float64 `yaml:"submit_rate"`
+	SubmitBurst int     `yaml:"submit_burst"`
+	// CallerSubmitRate and CallerSubmitBurst limit every caller of the
+	// tenant on its own. While authentication is off all callers are
+	// anonymous and share a single bucket.
+	CallerSubmitRate  float64 `yaml:"caller_submit_rate"`
+	CallerSubmitBurst int     `yaml:"caller_submit_burst"`
+	// MaxQueued limits the QUEUED tasks of the tenant.
+	MaxQueued int `yaml:"max_queued"`
+	// MaxInFlight limits the QUEUED and IN_PROGRESS tasks of the tenant.
+	MaxInFlight int `yaml:"max_in_flight"`
+}
+
+// validate checks that no limit is negative.
+func (c quotaConfig) validate() error {
+	if c.SubmitRate < 0 || c.SubmitBurst < 0 || c.CallerSubmitRate < 0 || c.CallerSubmitBurst < 0 || c.MaxQueued < 0 || c.MaxInFlight < 0 {
+		return errors.New("quota limits must not be negative")
+	}
+	return nil
+}
+
+// proto converts the quota for the API.
+func (c quotaConfig) proto() *pb.TenantQuota {
+	return &pb.TenantQuota{
+		SubmitRate:        c.SubmitRate,
+		SubmitBurst:       int32(c.SubmitBurst),
+		CallerSubmitRate:  c.CallerSubmitRate,
+		CallerSubmitBurst: int32(c.CallerSubmitBurst),
+		MaxQueued:         int32(c.MaxQueued),
+		MaxInFlight:       int32(c.MaxInFlight),
+	}
+}
+
+// quotaConfigFromProto converts a quota received over the API.
+func quotaConfigFromProto(q *pb.TenantQuota) quotaConfig {
+	return quotaConfig{
+		SubmitRate:        q.GetSubmitRate(),
+		SubmitBurst:       int(q.GetSubmitBurst()),
+		CallerSubmitRate:  q.GetCallerSubmitRate(),
+		CallerSubmitBurst: int(q.GetCallerSubmitBurst()),
+		MaxQueued:         int(q.GetMaxQueued()),
+		MaxInFlight:       int(q.GetMaxInFlight()),
+	}
+}
+
+// newLimiter returns a token bucket for the rate, or nil when the rate is
+// unlimited. The burst defaults to one second worth of tokens.
+func newLimiter(perSecond float64, burst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(perSecond)))
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// setQuota changes the quota of the tenant. Token buckets start out full.
+// The caller must hold the server lock.
+func (tn *tenant) setQuota(q quotaConfig) {
+	tn.config.quota = q
+	tn.limiter = newLimiter(q.SubmitRate, q.SubmitBurst)
+	tn.callerLimiters = make(map[string]*rate.Limiter)
+}
+
+// callerLimiter returns the token bucket of a caller of the tenant, or nil
+// when callers are not rate limited. The caller must hold the server lock.
+func (tn *tenant) callerLimiter(caller string, now time.Time) *rate.Limiter {
+	q := tn.config.quota
+	if q.CallerSubmitRate <= 0 {
+		return nil
+	}
+	lim, ok := tn.callerLimiters[caller]
+	if !ok {
+		tn.sweepCallerLimiters(now)
+		lim = newLimiter(q.CallerSubmitRate, q.CallerSubmitBurst)
+		tn.callerLimiters[caller] = lim
+	}
+	return lim
+}
+
+// sweepCallerLimiters drops the token buckets of callers that have not
+// submitted for long enough to fill them up again, at most every
+// callerSweepInterval. The caller must hold the server lock.
+func (tn *tenant) sweepCallerLimiters(now time.Time) {
+	if now.Sub(tn.callersSwept) < callerSweepInterval {
+		return
+	}
+	tn.callersSwept = now
+	for caller, lim := range tn.callerLimiters {
+		if lim.TokensAt(now) >= float64(lim.Burst()) {
+			delete(tn.callerLimiters, caller)
+		}
+	}
+}
+
+// admit checks a new task of the caller against the quota of the tenant and
+// takes a token from its buckets. The error is ResourceExhausted, with a
+// retry delay when a rate limit was hit. The caller must hold s.mu.
+func (tn *tenant) admit(caller string, now time.Time) error {
+	q := tn.config.quota
+	queued := tn.counts[statusQueued]
+	if q.MaxQueued > 0 && queued >= q.MaxQueued {
+		return tn.reject(rejectMaxQueued, 0, "tenant %q has reached its limit of %d queued tasks", tn.name, q.MaxQueued)
+	}
+	if q.MaxInFlight > 0 && queued+tn.counts[statusInProgress] >= q.MaxInFlight {
+		return tn.reject(rejectMaxInFlight, 0, "tenant %q has reached its limit of %d unfinished tasks", tn.name, q.MaxInFlight)
+	}
+
+	// Both buckets must have a token. A reservation is given back when the
+	// other bucket turns the task down.
+	var callerRes *rate.Reservation
+	if lim := tn.callerLimiter(caller, now); lim != nil {
+		callerRes = lim.ReserveN(now, 1)
+		if delay := callerRes.DelayFrom(now); delay > 0 {
+			callerRes.CancelAt(now)
+			return tn.reject(rejectCallerRate, delay, "caller %q is submitting tasks too fast", caller)
+		}
+	}
+	if tn.limiter != nil {
+		res := tn.limiter.ReserveN(now, 1)
+		if delay := res.DelayFrom(now); delay > 0 {
+			res.CancelAt(now)
+			if callerRes != nil {
+				callerRes.CancelAt(now)
+			}
+			return tn.reject(rejectRate, delay, "tenant %q is submitting tasks too fast", tn.name)
+		}
+	}
+	return nil
+}
+
+// reject counts a rejected submission and returns a ResourceExhausted error
+// describing the violated quota, with a RetryInfo detail when the caller can
+// retry after a known delay.
+func (tn *tenant) reject(reason string, retryAfter time.Duration, format string, args ...any) error {
+	tn.rejected[reason]++
+	msg := fmt.Sprintf(format, args...)
+	st := status.New(codes.ResourceExhausted, msg)
+	failure := &errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{{Subject: "tenant:" + tn.name, Description: reason}},
+	}
+	var err error
+	if retryAfter > 0 {
+		st, err = st.WithDetails(failure, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	} else {
+		st, err = st.WithDetails(failure)
+	}
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
+
+// usage reports the quota usage of the tenant. The caller must hold the server
+// lock.
+func (tn *tenant) usage(now time.Time) *pb.TenantUsage {
+	res := &pb.TenantUsage{
+		Tenant:   tn.name,
+		Quota:    tn.config.quota.proto(),
+		Queued:   int32(tn.counts[statusQueued]),
+		InFlight: int32(tn.counts[statusQueued] + tn.counts[statusInProgress]),
+		Rejected: make(map[string]int64),
+	}
+	if tn.limiter != nil {
+		tokens := tn.limiter.TokensAt(now)
+		res.SubmitTokens = &tokens
+	}
+	for reason, n := range tn.rejected {
+		res.Rejected[reason] = n
+	}
+	return res
+}
+
+// GetTenantUsage reports the quota usage of the caller's tenant or, for
+// operators, of any tenant.
+func (s *server) GetTenantUsage(ctx context.Context, req *pb.TenantUsageRequest) (*pb.TenantUsage, error) {
+	name := req.GetTenant()
+	if name == "" {
+		name = tenantName(ctx)
+	}
+	if name != tenantName(ctx) {
+		if err := checkPermission(ctx, permTenants, "read the usage of other tenants"); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tn, exists := s.tenants[name]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "tenant %q not found", name)
+	}
+	return tn.usage(time.Now()), nil
+}
+
+// registerQuotaMetrics reports the usage and rejected submissions of every
+// tenant through the meter.
+func (s *server) registerQuotaMetrics(meter metric.Meter) error {
+	queued, err := meter.Int64ObservableGauge("taskmanager.tenant.queued",
+		metric.WithDescription("Number of QUEUED tasks of a tenant."))
+	if err != nil {
+		return err
+	}
+	inFlight, err := meter.Int64ObservableGauge("taskmanager.tenant.in_flight",
+		metric.WithDescription("Number of QUEUED and IN_PROGRESS tasks of a tenant."))
+	if err != nil {
+		return err
+	}
+	rejected, err := meter.Int64ObservableCounter("taskmanager.tenant.rejected",
+		metric.WithDescription("Number of submissions rejected by the quota of a tenant, by reason."))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for _, tn := range s.tenants {
+			attrs := metric.WithAttributes(attribute.String("tenant", tn.name))
+			o.ObserveInt64(queued, int64(tn.counts[statusQueued]), attrs)
+			o.ObserveInt64(inFlight, int64(tn.counts[statusQueued]+tn.counts[statusInProgress]), attrs)
+			for reason, n := range tn.rejected {
+				o.ObserveInt64(rejected, n, metric.WithAttributes(
+					attribute.String("tenant", tn.name),
+					attribute.String("reason", reason),
+				))
+			}
+		}
+		return nil
+	}, queued, inFlight, rejected)
+	return err
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rejection returns the reason and retry delay of a quota rejection, failing
+// the test if err is not one.
+func rejection(t *testing.T, err error) (reason string, retryAfter time.Duration) {
+	t.Helper()
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		t.Fatalf("got %v, want a %v error", err, codes.ResourceExhausted)
+	}
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.QuotaFailure:
+			if len(d.Violations) != 1 {
+				t.Fatalf("QuotaFailure has %d violations, want 1", len(d.Violations))
+			}
+			reason = d.Violations[0].Description
+			if d.Violations[0].Subject != "tenant:acme" {
+				t.Errorf("QuotaFailure subject %q, want %q", d.Violations[0].Subject, "tenant:acme")
+			}
+		case *errdetails.RetryInfo:
+			retryAfter = d.RetryDelay.AsDuration()
+		}
+	}
+	if reason == "" {
+		t.Fatalf("%v has no QuotaFailure detail", err)
+	}
+	return reason, retryAfter
+}
+
+func TestQuotaRefill(t *testing.T) {
+	tn := newTenant("acme", tenantConfig{quota: quotaConfig{SubmitRate: 10, SubmitBurst: 2}})
+	now := time.Now()
+
+	for i := range 2 {
+		if err := tn.admit("alice", now); err != nil {
+			t.Fatalf("submission %d within the burst: %v", i+1, err)
+		}
+	}
+	reason, retryAfter := rejection(t, tn.admit("alice", now))
+	if reason != rejectRate {
+		t.Errorf("rejected for %q, want %q", reason, rejectRate)
+	}
+	if retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+		t.Errorf("retry after %s, want up to 100ms", retryAfter)
+	}
+	// Rejected submissions do not use up tokens.
+	if _, again := rejection(t, tn.admit("alice", now)); again != retryAfter {
+		t.Errorf("second rejection retries after %s, want %s", again, retryAfter)
+	}
+
+	// After the retry delay a token is back, but only one.
+	now = now.Add(retryAfter)
+	if err := tn.admit("alice", now); err != nil {
+		t.Fatalf("submission after the retry delay: %v", err)
+	}
+	rejection(t, tn.admit("alice", now))
+
+	// Given time the bucket fills up to the burst again.
+	now = now.Add(time.Second)
+	for i := range 2 {
+		if err := tn.admit("alice", now); err != nil {
+			t.Fatalf("submission %d after a refill: %v", i+1, err)
+		}
+	}
+	rejection(t, tn.admit("alice", now))
+
+	if got := tn.rejected[rejectRate]; got != 4 {
+		t.Errorf("counted %d rejections, want 4", got)
+	}
+}
+
+func TestQuotaCallerAndTenantLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		quota quotaConfig
+		// callers submit in order; want holds the reason each is rejected
+		// for, empty when it is admitted.
+		callers []string
+		want    []string
+	}{{
+		name:    "callers limited separately",
+		quota:   quotaConfig{CallerSubmitRate: 1, CallerSubmitBurst: 1},
+		callers: []string{"alice", "alice", "bob", "bob", "carol"},
+		want:    []string{"", rejectCallerRate, "", rejectCallerRate, ""},
+	}, {
+		name:    "tenant limit shared by callers",
+		quota:   quotaConfig{SubmitRate: 1, SubmitBurst: 2, CallerSubmitRate: 10, CallerSubmitBurst: 10},
+		callers: []string{"alice", "bob", "carol", "alice"},
+		want:    []string{"", "", rejectRate, rejectRate},
+	}, {
+		name:    "both limits",
+		quota:   quotaConfig{SubmitRate: 1, SubmitBurst: 3, CallerSubmitRate: 1, CallerSubmitBurst: 2},
+		callers: []string{"alice", "alice", "alice", "bob", "bob"},
+		want:    []string{"", "", rejectCallerRate, "", rejectRate},
+	}, {
+		// Without authentication every caller is anonymous.
+		name:    "anonymous callers share a bucket",
+		quota:   quotaConfig{CallerSubmitRate: 1, CallerSubmitBurst: 2},
+		callers: []string{"", "", ""},
+		want:    []string{"", "", rejectCallerRate},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tn := newTenant("acme", tenantConfig{quota: tt.quota})
+			now := time.Now()
+			for i, caller := range tt.callers {
+				err := tn.admit(caller, now)
+				if tt.want[i] == "" {
+					if err != nil {
+						t.Errorf("submission %d by %q: %v", i+1, caller, err)
+					}
+					continue
+				}
+				if reason, _ := rejection(t, err); reason != tt.want[i] {
+					t.Errorf("submission %d by %q rejected for %q, want %q", i+1, caller, reason, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaTenantRejectionRefundsCaller(t *testing.T) {
+	tn := newTenant("acme", tenantConfig{quota: quotaConfig{SubmitRate: 1, SubmitBurst: 1, CallerSubmitRate: 1, CallerSubmitBurst: 1}})
+	now := time.Now()
+
+	if err := tn.admit("alice", now); err != nil {
+		t.Fatal(err)
+	}
+	// Bob is turned down by the tenant bucket and keeps its own token.
+	if reason, _ := rejection(t, tn.admit("bob", now)); reason != rejectRate {
+		t.Fatalf("rejected for %q, want %q", reason, rejectRate)
+	}
+	if tokens := tn.callerLimiters["bob"].TokensAt(now); tokens != 1 {
+		t.Errorf("bob has %v tokens left, want 1", tokens)
+	}
+}
+
+func TestQuotaTaskLimits(t *testing.T) {
+	tn := newTenant("acme", tenantConfig{quota: quotaConfig{MaxQueued: 2, MaxInFlight: 3}})
+	now := time.Now()
+
+	tn.counts[statusQueued] = 2
+	reason, retryAfter := rejection(t, tn.admit("alice", now))
+	if reason != rejectMaxQueued {
+		t.Errorf("rejected for %q, want %q", reason, rejectMaxQueued)
+	}
+	// There is no telling when a task leaves the queue.
+	if retryAfter != 0 {
+		t.Errorf("retry after %s, want no RetryInfo", retryAfter)
+	}
+
+	tn.counts[statusQueued] = 1
+	tn.counts[statusInProgress] = 2
+	if reason, _ := rejection(t, tn.admit("alice", now)); reason != rejectMaxInFlight {
+		t.Errorf("rejected for %q, want %q", reason, rejectMaxInFlight)
+	}
+
+	tn.counts[statusInProgress] = 1
+	if err := tn.admit("alice", now); err != nil {
+		t.Errorf("submission below the limits: %v", err)
+	}
+}
+
+func TestQuotaSweepsIdleCallers(t *testing.T) {
+	tn := newTenant("acme", tenantConfig{quota: quotaConfig{CallerSubmitRate: 1, CallerSubmitBurst: 5}})
+	now := time.Now()
+
+	for _, caller := range []string{"alice", "bob"} {
+		if err := tn.admit(caller, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Alice keeps submitting, Bob goes away and the bucket of Bob fills up.
+	for range callerSweepInterval / time.Second {
+		now = now.Add(time.Second)
+		if err := tn.admit("alice", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The next new caller triggers a sweep.
+	if err := tn.admit("carol", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tn.callerLimiters["bob"]; ok {
+		t.Error("bucket of an idle caller was kept")
+	}
+	for _, caller := range []string{"alice", "carol"} {
+		if _, ok := tn.callerLimiters[caller]; !ok {
+			t.Errorf("bucket of active caller %s was dropped", caller)
+		}
+	}
+
+	// Callers do not get extra tokens from having their bucket dropped.
+	for range 5 {
+		if err := tn.admit("bob", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reason, _ := rejection(t, tn.admit("bob", now)); reason != rejectCallerRate {
+		t.Errorf("rejected for %q, want %q", reason, rejectCallerRate)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ttl returns how long a task that finished in the given status is kept.
+// Zero means the task is kept forever.
+func (r retentionConfig) ttl(status string) time.Duration {
+	switch status {
+	case statusCompleted:
+		return r.Completed
+	case statusFailed:
+		return r.Failed
+	case statusCancelled:
+		return r.Cancelled
+	}
+	return 0
+}
+
+// archivedTask is the record of an expired task written to the archive file.
+type archivedTask struct {
+	ID          string            `json:"id"`
+	Tenant      string            `json:"tenant"`
+	Owner       string            `json:"owner,omitempty"`
+	Queue       string            `json:"queue"`
+	Description string            `json:"description"`
+	Priority    string            `json:"priority"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Status      string            `json:"status"`
+	CreatedAt   time.Time         `json:"created_at"`
+	FinishedAt  time.Time         `json:"finished_at"`
+	History     []archivedEvent   `json:"history"`
+}
+
+type archivedEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// newArchivedTask copies a task for the archive. The caller must hold the
+// server lock.
+func newArchivedTask(t *task) archivedTask {
+	record := archivedTask{
+		ID:          t.id,
+		Tenant:      t.tenant,
+		Owner:       t.owner,
+		Queue:       t.queue,
+		Description: t.description,
+		Priority:    t.priority,
+		Labels:      maps.Clone(t.labels),
+		Status:      t.status,
+		CreatedAt:   t.createdAt,
+		FinishedAt:  t.finishedAt,
+	}
+	for _, event := range t.history {
+		record.History = append(record.History, archivedEvent{Time: event.time, Message: event.message})
+	}
+	return record
+}
+
+// archiveTasks appends the records to the archive file as JSON lines. The
+// file is opened for every batch so it can be rotated by external tools.
+func archiveTasks(path string, records []archivedTask) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return errors.Join(err, f.Close())
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	return f.Close()
+}
+
+// reapExpired deletes the finished tasks that are past their retention and
+// returns how many were deleted. With an archive file configured, tasks are
+// only deleted once they have been written to it.
+func (s *server) reapExpired(now time.Time) (int, error) {
+	s.mu.Lock()
+	archiveFile := s.retention.ArchiveFile
+	var expired []*task
+	var records []archivedTask
+	for _, task := range s.tasks {
+		if !isTerminal(task.status) {
+			continue
+		}
+		ttl := s.retentionFor(task)
+		if ttl > 0 && now.Sub(task.finishedAt) >= ttl {
+			expired = append(expired, task)
+			if archiveFile != "" {
+				records = append(records, newArchivedTask(task))
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	// Write the archive without holding the lock; tasks that changed in
+	// the meantime are kept and looked at again in the next round.
+	if archiveFile != "" {
+		if err := archiveTasks(archiveFile, records); err != nil {
+			s.mu.Lock()
+			s.archiveErrors++
+			s.mu.Unlock()
+			return 0, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reaped := 0
+	for i, task := range expired {
+		if s.tasks[task.id] != task || !isTerminal(task.status) {
+			continue
+		}
+		if archiveFile != "" && !task.finishedAt.Equal(records[i].FinishedAt) {
+			continue
+		}
+		s.reaped[task.status]++
+		s.deleteTask(task)
+		reaped++
+	}
+	return reaped, nil
+}
+
+// runReaper deletes expired tasks every retention interval until the server
+// shuts down.
+func (s *server) runReaper() {
+	s.mu.Lock()
+	s.reaperRunning = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.reaperRunning = false
+		s.mu.Unlock()
+	}()
+
+	for {
+		s.mu.Lock()
+		interval := s.retention.Interval
+		s.mu.Unlock()
+
+		select {
+		case now := <-time.After(interval):
+			n, err := s.reapExpired(now)
+			if err != nil {
+				slog.Error("expired tasks not deleted, archiving failed", "error", err)
+			}
+			if n > 0 {
+				slog.Info("deleted expired tasks", "count", n)
+			}
+		case <-s.stopping:
+			return
+		}
+	}
+}
+
+// registerRetentionMetrics reports the number of deleted expired tasks and
+// failed archive writes through the meter.
+func (s *server) registerRetentionMetrics(meter metric.Meter) error {
+	reaped, err := meter.Int64ObservableCounter("taskmanager.tasks.reaped",
+		metric.WithDescription("Number of expired tasks deleted by the reaper, by final status."))
+	if err != nil {
+		return err
+	}
+	archiveErrors, err := meter.Int64ObservableCounter("taskmanager.tasks.archive_errors",
+		metric.WithDescription("Number of times expired tasks could not be archived."))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for status, n := range s.reaped {
+			o.ObserveInt64(reaped, n, metric.WithAttributes(attribute.String("status", status)))
+		}
+		o.ObserveInt64(archiveErrors, s.archiveErrors)
+		return nil
+	}, reaped, archiveErrors)
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+)
+
+// finish gives a queued task a final status, as if it finished at the time.
+func finish(s *server, t *task, status string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dequeue(t)
+	s.setStatus(t, status)
+	t.finishedAt = at
+}
+
+func TestReapExpired(t *testing.T) {
+	s := newServer()
+	s.retention = retentionConfig{Completed: time.Hour, Cancelled: time.Minute}
+	s.tenants["acme"] = newTenant("acme", tenantConfig{retention: retentionConfig{Completed: 10 * time.Minute}})
+	for _, tn := range s.tenants {
+		tn.queues[defaultQueue].paused = true
+	}
+	submitAs := func(name, tenant string, labels map[string]string) *task {
+		t.Helper()
+		res, err := s.SubmitTask(as(name, tenant, roleSubmitter), &pb.TaskRequest{TaskDescription: "test", Labels: labels})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.tasks[res.TaskId]
+	}
+	base := time.Now()
+	completed := submitAs("alice", defaultTenant, map[string]string{"team": "a"})
+	cancelled := submitAs("alice", defaultTenant, nil)
+	failed := submitAs("bob", defaultTenant, nil)
+	queued := submitAs("bob", defaultTenant, nil)
+	theirs := submitAs("carol", "acme", nil)
+	finish(s, completed, statusCompleted, base)
+	finish(s, cancelled, statusCancelled, base)
+	finish(s, failed, statusFailed, base)
+	finish(s, theirs, statusCompleted, base)
+	s.mu.Lock()
+	updates := make(chan string, 1)
+	s.subscribers[completed.id] = updates
+	s.mu.Unlock()
+
+	// The steps reap in order, each later than the one before.
+	steps := []struct {
+		name  string
+		after time.Duration
+		// reaped is the number of tasks deleted by the step, and kept the
+		// tasks left afterwards.
+		reaped int
+		kept   []*task
+	}{
+		{"nothing expired yet", 30 * time.Second, 0, []*task{completed, cancelled, failed, queued, theirs}},
+		{"CANCELLED and tenant override expired", 30 * time.Minute, 2, []*task{completed, failed, queued}},
+		{"COMPLETED expired", 2 * time.Hour, 1, []*task{failed, queued}},
+		{"FAILED kept forever", 365 * 24 * time.Hour, 0, []*task{failed, queued}},
+	}
+	for _, tt := range steps {
+		n, err := s.reapExpired(base.Add(tt.after))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if n != tt.reaped {
+			t.Errorf("%s: reaped %d tasks, want %d", tt.name, n, tt.reaped)
+		}
+		if len(s.tasks) != len(tt.kept) {
+			t.Errorf("%s: %d tasks left, want %d", tt.name, len(s.tasks), len(tt.kept))
+		}
+		for _, task := range tt.kept {
+			if s.tasks[task.id] != task {
+				t.Errorf("%s: %s task %s was deleted", tt.name, task.status, task.id)
+			}
+		}
+	}
+
+	if got := s.reaped; got[statusCompleted] != 2 || got[statusCancelled] != 1 || got[statusFailed] != 0 {
+		t.Errorf("reaped by status %v, want 2 COMPLETED and 1 CANCELLED", got)
+	}
+	// The deleted tasks no longer count in the statistics or the index.
+	tn := s.tenants[defaultTenant]
+	if tn.counts[statusCompleted] != 0 || tn.counts[statusCancelled] != 0 || tn.counts[statusFailed] != 1 || tn.counts[statusQueued] != 1 {
+		t.Errorf("tenant counts %v, want one FAILED and one QUEUED task", tn.counts)
+	}
+	if n := s.tenants["acme"].counts[statusCompleted]; n != 0 {
+		t.Errorf("acme counts %d COMPLETED tasks, want 0", n)
+	}
+	if len(tn.labelIndex) != 0 {
+		t.Errorf("label index still holds %v", tn.labelIndex)
+	}
+	if _, ok := <-updates; ok {
+		t.Error("status updates of a deleted task still open")
+	}
+	// Owners without tasks left lose their statistics.
+	if _, ok := tn.ownerStats["alice"]; ok {
+		t.Error("statistics of alice kept after all their tasks were deleted")
+	}
+	if _, ok := tn.ownerStats["bob"]; !ok {
+		t.Error("statistics of bob dropped while they still have tasks")
+	}
+	if _, ok := s.tenants["acme"].ownerStats["carol"]; ok {
+		t.Error("statistics of carol kept after all their tasks were deleted")
+	}
+}
+
+func TestReapExpiredArchive(t *testing.T) {
+	s := newServer()
+	s.tenants[defaultTenant].queues[defaultQueue].paused = true
+	s.retention = retentionConfig{Completed: time.Minute, ArchiveFile: filepath.Join(t.TempDir(), "missing", "archive.jsonl")}
+	task := submit(t, s, "", "HIGH")
+	now := time.Now()
+	finish(s, task, statusCompleted, now)
+
+	// Tasks are kept while the archive cannot be written.
+	if _, err := s.reapExpired(now.Add(time.Hour)); err == nil {
+		t.Error("reaping with an unwritable archive succeeded")
+	}
+	if s.tasks[task.id] != task || s.archiveErrors != 1 {
+		t.Errorf("after a failed archive write the task is kept %t with %d errors, want kept with 1", s.tasks[task.id] == task, s.archiveErrors)
+	}
+
+	s.retention.ArchiveFile = filepath.Join(t.TempDir(), "archive.jsonl")
+	if n, err := s.reapExpired(now.Add(time.Hour)); n != 1 || err != nil {
+		t.Fatalf("reapExpired = %d, %v, want 1 task", n, err)
+	}
+	f, err := os.Open(s.retention.ArchiveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	var records []archivedTask
+	for sc.Scan() {
+		var record archivedTask
+		if err := json.Unmarshal(sc.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 1 || records[0].ID != task.id || records[0].Status != statusCompleted || records[0].Priority != "HIGH" || len(records[0].History) == 0 {
+		t.Errorf("archive holds %+v, want the COMPLETED task with its history", records)
+	}
+}
+
+func TestDefaultRetention(t *testing.T) {
+	r := defaultConfig().Retention
+	for _, st := range []string{statusCompleted, statusFailed, statusCancelled} {
+		if r.ttl(st) <= 0 {
+			t.Errorf("%s tasks are kept forever by default", st)
+		}
+	}
+	for _, st := range []string{statusQueued, statusInProgress} {
+		if r.ttl(st) != 0 {
+			t.Errorf("%s tasks expire after %s, want never", st, r.ttl(st))
+		}
+	}
+}
@@ -1,33 +1,121 @@
 package main
 
 import (
+	"container/list"
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"math/rand"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	pb "github.com/maciekb2/task-manager/proto"
+	"github.com/maciekb2/task-manager/telemetry"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/prometheus"
-	"go.opentelemetry.io/otel/sdk/resource"
-	tracesdk "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// Task statuses reported to clients.
+const (
+	statusQueued     = "QUEUED"
+	statusInProgress = "IN_PROGRESS"
+	statusCompleted  = "COMPLETED"
+	statusFailed     = "FAILED"
+	statusCancelled  = "CANCELLED"
+)
+
+// isTerminal reports whether a task in the given status will not change
+// status again on its own.
+func isTerminal(status string) bool {
+	return status == statusCompleted || status == statusFailed || status == statusCancelled
+}
+
+// validPriority reports whether p is one of the supported task priorities.
+func validPriority(p string) bool {
+	return p == "LOW" || p == "MEDIUM" || p == "HIGH"
+}
+
+// maxTaskHistory bounds the number of events kept for each task.
+const maxTaskHistory = 100
+
+// taskEvent is a single entry in the history of a task.
+type taskEvent struct {
+	time    time.Time
+	message string
+}
+
 // task represents a single task with its properties.
 type task struct {
 	id          string
 	description string
 	priority    string
 	status      string
+	labels      map[string]string
+	queue       string
+	createdAt   time.Time
+	// tenant is the tenant the task and its queue belong to.
+	tenant string
+	// owner is the principal that submitted the task, empty when
+	// authentication was off.
+	owner string
+	// finishedAt is when the task last reached a final status.
+	finishedAt time.Time
+	// queuedAt is when the task last entered its queue, and startedAt when
+	// it was last started.
+	queuedAt  time.Time
+	startedAt time.Time
+	// index is the position of the task in its queue, or -1 when it is not
+	// waiting there.
+	index int
+	// attempt is incremented every time the task is (re)started, so that a
+	// stale processTask goroutine can tell it no longer owns the task.
+	attempt int
+	// retries counts the automatic retries made under the queue retry policy.
+	retries int
+	// spanContext is the span of the SubmitTask call, the parent of the
+	// spans of the task. requeuedBy is the span of a later call that queued
+	// the task again, linked from the next execute span.
+	spanContext trace.SpanContext
+	requeuedBy  trace.SpanContext
+	// span is the execute span of the running attempt, if any.
+	span trace.Span
+	// waiting holds the entries of the task in the waiting tasks of its
+	// statistics while it is QUEUED.
+	waiting []*list.Element
+	// cancel stops the running attempt, if any.
+	cancel  context.CancelFunc
+	history []taskEvent
+}
+
+// record appends an event to the history of the task, dropping the oldest
+// event once the history is full. The caller must hold the server lock.
+func (t *task) record(format string, args ...any) {
+	if len(t.history) == maxTaskHistory {
+		t.history = append(t.history[:0], t.history[1:]...)
+	}
+	t.history = append(t.history, taskEvent{time: time.Now(), message: fmt.Sprintf(format, args...)})
 }
 
 // server is the gRPC server implementation for the TaskManager service.
@@ -36,6 +124,41 @@ type server struct {
 	tasks       map[string]*task
 	mu          sync.Mutex
 	subscribers map[string]chan string
+	// streams counts the open StreamTaskStatus streams of every task.
+	streams map[string]int
+	tenants map[string]*tenant
+	// paused stops tasks from being started on every queue.
+	paused bool
+	// shuttingDown is set once the server stops accepting and starting
+	// tasks, and stopping is closed at the same time. streamsDone is closed
+	// once running tasks finished or were interrupted, to end status streams.
+	shuttingDown bool
+	stopping     chan struct{}
+	streamsDone  chan struct{}
+	// inFlight counts the running processTask goroutines.
+	inFlight sync.WaitGroup
+	// workers is the concurrency limit of the default queue last set by the
+	// configuration. It is only applied again when it changes, so a limit
+	// set with UpdateQueue survives reloads.
+	workers int
+	// retention sets how long finished tasks are kept. reaped counts the
+	// expired tasks deleted by the reaper, by status, and archiveErrors the
+	// failed writes to the archive file.
+	retention     retentionConfig
+	reaped        map[string]int64
+	archiveErrors int64
+	admission     admissionControl
+	metrics       taskMetrics
+	// reaperRunning is set while the reaper deletes expired tasks.
+	reaperRunning bool
+	// audit receives the records of mutating calls; nil while the audit
+	// log is off.
+	audit auditSink
+	// authn checks the credentials of callers; nil while authentication is
+	// off.
+	authn atomic.Pointer[authenticator]
+	// work runs a task and returns the status it finished with.
+	work func(ctx context.Context, t *task) string
 }
 
 // newServer creates a new server instance.
@@ -43,29 +166,101 @@ func newServer() *server {
 	return &server{
 		tasks:       make(map[string]*task),
 		subscribers: make(map[string]chan string),
+		streams:     make(map[string]int),
+		tenants: map[string]*tenant{
+			defaultTenant: newTenant(defaultTenant, tenantConfig{}),
+		},
+		stopping:    make(chan struct{}),
+		streamsDone: make(chan struct{}),
+		reaped:      make(map[string]int64),
+		admission:   admissionControl{rejected: make(map[string]int64)},
+		metrics:     noopTaskMetrics(),
+		work:        simulateWork,
 	}
 }
 
-// SubmitTask adds a new task with a given priority.
+// SubmitTask adds a new task with a given priority to a queue of the caller's
+// tenant. Tasks without a priority get the default priority of their queue,
+// lowered to the priority ceiling of the tenant if needed.
 // It returns a TaskResponse with the new task's ID or an error.
 func (s *server) SubmitTask(ctx context.Context, req *pb.TaskRequest) (*pb.TaskResponse, error) {
+	if req.Priority != "" && !validPriority(req.Priority) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid priority %q", req.Priority)
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	queueName := req.Queue
+	if queueName == "" {
+		queueName = defaultQueue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown {
+		return nil, status.Error(codes.Unavailable, "server is shutting down")
+	}
+	tn, err := s.callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Priority != "" {
+		if err := tn.checkPriority(req.Priority); err != nil {
+			return nil, err
+		}
+	}
+	q, err := tn.queueByName(queueName)
+	if err != nil {
+		return nil, err
+	}
+	if q.draining {
+		return nil, status.Errorf(codes.FailedPrecondition, "queue %q is draining", queueName)
+	}
+	priority := req.Priority
+	if priority == "" {
+		priority = q.config.defaultPriority
+		if !tn.allows(priority) {
+			priority = tn.config.maxPriority
+		}
+	}
+	now := time.Now()
+	if err := s.admit(priority, now); err != nil {
+		return nil, err
+	}
+	if err := tn.admit(callerName(ctx), now); err != nil {
+		return nil, err
+	}
+
 	taskID := fmt.Sprintf("%d", rand.Int())
 	task := &task{
 		id:          taskID,
 		description: req.TaskDescription,
-		priority:    req.Priority,
-		status:      "QUEUED",
+		priority:    priority,
+		status:      statusQueued,
+		labels:      maps.Clone(req.Labels),
+		queue:       queueName,
+		tenant:      tn.name,
+		owner:       callerName(ctx),
+		createdAt:   now,
+		index:       -1,
+		spanContext: trace.SpanContextFromContext(ctx),
 	}
+	task.record("submitted to queue %s with priority %s", queueName, task.priority)
 
-	s.mu.Lock()
 	s.tasks[taskID] = task
+	s.countTask(task, 1)
+	s.startWaiting(task, now)
+	s.recordSubmitted(task, now)
+	s.indexLabels(task)
+	s.metrics.submitted.Add(ctx, 1, taskAttributes(task))
 	if _, exists := s.subscribers[taskID]; !exists {
 		s.subscribers[taskID] = make(chan string, 10)
 	}
-	s.mu.Unlock()
 
-	// Asynchronously process the task.
-	go s.processTask(taskID)
+	// The task is processed asynchronously once its queue has room for it.
+	s.enqueue(task)
+	slog.InfoContext(ctx, "task submitted", "task_id", taskID, "queue", queueName, "priority", priority)
 
 	return &pb.TaskResponse{TaskId: taskID}, nil
 }
@@ -74,149 +269,451 @@ func (s *server) SubmitTask(ctx context.Context, req *pb.TaskRequest) (*pb.TaskR
 // It returns a StatusResponse with the task's status or an error if the task is not found.
 func (s *server) CheckTaskStatus(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
 	s.mu.Lock()
-	task, exists := s.tasks[req.TaskId]
-	s.mu.Unlock()
+	defer s.mu.Unlock()
 
-	if !exists {
+	// Tasks of other principals are reported as unknown so their IDs cannot
+	// be probed.
+	task, exists := s.tasks[req.TaskId]
+	if !exists || !canSee(ctx, task) {
 		return &pb.StatusResponse{Status: "UNKNOWN TASK"}, nil
 	}
 
-	return &pb.StatusResponse{Status: task.status}, nil
+	res := &pb.StatusResponse{Status: task.status}
+	for _, event := range task.history {
+		res.History = append(res.History, &pb.TaskEvent{
+			Time:    timestamppb.New(event.time),
+			Message: event.message,
+		})
+	}
+	return res, nil
 }
 
 // StreamTaskStatus sends the status of a task in real-time.
-// It streams StatusResponse messages to the client.
+// It streams StatusResponse messages to the client until the task finishes,
+// the client goes away or the server shuts down.
 func (s *server) StreamTaskStatus(req *pb.StatusRequest, stream pb.TaskManager_StreamTaskStatusServer) error {
 	s.mu.Lock()
 	ch, exists := s.subscribers[req.TaskId]
+	task, ok := s.tasks[req.TaskId]
+	if !ok || !canSee(stream.Context(), task) {
+		exists = false
+	}
+	if exists {
+		s.streams[req.TaskId]++
+	}
 	s.mu.Unlock()
 
 	if !exists {
 		return fmt.Errorf("task not found")
 	}
-
-	for status := range ch {
-		if err := stream.Send(&pb.StatusResponse{Status: status}); err != nil {
-			return err
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.streams[req.TaskId]--; s.streams[req.TaskId] == 0 {
+			delete(s.streams, req.TaskId)
 		}
-		if status == "COMPLETED" || status == "FAILED" {
-			break
+	}()
+	// Tie the watcher's trace to the trace of the task it follows.
+	span := trace.SpanFromContext(stream.Context())
+	span.AddLink(trace.Link{SpanContext: task.spanContext})
+	s.metrics.streams.Add(stream.Context(), 1)
+	defer s.metrics.streams.Add(context.Background(), -1)
+
+	for {
+		select {
+		case update, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			span.AddEvent("status changed", trace.WithAttributes(attribute.String("task.status", update)))
+			if err := stream.Send(&pb.StatusResponse{Status: update}); err != nil {
+				return err
+			}
+			if isTerminal(update) {
+				return nil
+			}
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-s.streamsDone:
+			// Send the updates still buffered, such as the status the
+			// task was left in, before ending the stream.
+			if len(ch) == 0 {
+				return status.Error(codes.Unavailable, "server is shutting down")
+			}
 		}
 	}
-	return nil
 }
 
-
+// GetStatistics returns the number of tasks of the caller's tenant in each
+// status, per queue, per priority and optionally grouped by the value of a
+// label, together with the recent throughput and latencies.
 func (s *server) GetStatistics(ctx context.Context, req *pb.StatisticsRequest) (*pb.StatisticsResponse, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	stats := &pb.StatisticsResponse{}
-	for _, task := range s.tasks {
-		switch task.status {
-		case "QUEUED":
-			stats.Queued++
-		case "IN_PROGRESS":
-			stats.InProgress++
-		case "COMPLETED":
-			stats.Completed++
-		case "FAILED":
-			stats.Failed++
+	tn, err := s.callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := &pb.StatisticsResponse{
+		ByQueue:      make(map[string]*pb.StatusCounts),
+		ByPriority:   make(map[string]*pb.StatusCounts),
+		Paused:       s.paused,
+		PausedQueues: tn.pausedQueues(),
+	}
+	for name := range tn.queues {
+		stats.ByQueue[name] = &pb.StatusCounts{}
+	}
+	for _, priority := range []string{"HIGH", "MEDIUM", "LOW"} {
+		stats.ByPriority[priority] = &pb.StatusCounts{}
+	}
+	if req.GetGroupByLabel() != "" {
+		stats.ByLabel = make(map[string]*pb.StatusCounts)
+		for value, ids := range tn.labelIndex[req.GetGroupByLabel()] {
+			counts, visible := &pb.StatusCounts{}, false
+			for id := range ids {
+				if task := s.tasks[id]; canSee(ctx, task) {
+					countStatus(counts, task.status, 1)
+					visible = true
+				}
+			}
+			if visible {
+				stats.ByLabel[value] = counts
+			}
 		}
 	}
+	// Callers that only see their own tasks get the statistics of those.
+	st := callerStats(ctx, tn)
+	if st == nil {
+		st = newTaskStats()
+	}
+	st.proto(stats, time.Now())
 	return stats, nil
 }
 
-// processTask simulates the processing of a task.
-func (s *server) processTask(taskID string) {
+// countStatus adds n tasks in the given status to the counts.
+func countStatus(counts *pb.StatusCounts, status string, n int) {
+	switch status {
+	case statusQueued:
+		counts.Queued += int32(n)
+	case statusInProgress:
+		counts.InProgress += int32(n)
+	case statusCompleted:
+		counts.Completed += int32(n)
+	case statusFailed:
+		counts.Failed += int32(n)
+	case statusCancelled:
+		counts.Cancelled += int32(n)
+	}
+}
+
+// processTask runs the work of a task started by dispatch. The
+// attempt must match the task's current attempt, otherwise the task has been
+// restarted or cancelled in the meantime and the result is dropped. Either
+// way the task's slot in its queue is released.
+func (s *server) processTask(ctx context.Context, task *task, attempt int) {
+	defer s.inFlight.Done()
+	slog.InfoContext(ctx, "task started", "attempt", attempt)
+
+	result := s.work(ctx, task)
+
 	s.mu.Lock()
-	task := s.tasks[taskID]
-	s.mu.Unlock()
+	defer s.mu.Unlock()
 
-	// Update status to IN_PROGRESS.
-	s.updateTaskStatus(taskID, "IN_PROGRESS")
-	time.Sleep(5 * time.Second) // Simulate processing time.
+	q := s.queueOf(task)
+	q.running--
+	q.tenant.running--
+	defer s.dispatchTenant(q.tenant)
 
-	// Simulate success or failure.
-	if rand.Float32() < 0.8 {
-		s.updateTaskStatus(taskID, "COMPLETED")
-	} else {
-		s.updateTaskStatus(taskID, "FAILED")
+	if ctx.Err() != nil || s.tasks[task.id] != task || task.attempt != attempt || task.status != statusInProgress {
+		slog.DebugContext(ctx, "task result dropped", "attempt", attempt)
+		return
+	}
+	task.cancel()
+	task.cancel = nil
+	if result == statusFailed && task.retries < q.config.maxRetries {
+		slog.WarnContext(ctx, "task failed, retrying", "attempt", attempt, "retry", task.retries+1, "backoff", q.config.retryBackoff)
+		s.scheduleRetry(task, q)
+		return
+	}
+	level := slog.LevelInfo
+	if result == statusFailed {
+		level = slog.LevelWarn
 	}
+	slog.Log(ctx, level, "task finished", "attempt", attempt, "status", result)
+	s.setStatus(task, result)
 }
 
-// updateTaskStatus updates the status of a task and notifies subscribers.
-func (s *server) updateTaskStatus(taskID, status string) {
-	s.mu.Lock()
-	if task, exists := s.tasks[taskID]; exists {
-		task.status = status
-		if ch, ok := s.subscribers[taskID]; ok {
-			ch <- status
+// simulateWork stands in for the work of a task: it takes five seconds and
+// fails one time in five.
+func simulateWork(ctx context.Context, t *task) string {
+	select {
+	case <-time.After(5 * time.Second):
+	case <-ctx.Done():
+	}
+	if rand.Float32() >= 0.8 {
+		return statusFailed
+	}
+	return statusCompleted
+}
+
+// setStatus updates the status of a task and notifies subscribers.
+// The caller must hold s.mu.
+func (s *server) setStatus(task *task, status string) {
+	now := time.Now()
+	s.recordTransition(task, status, now)
+	s.recordStats(task, status, now)
+	traceStatus(task, status)
+	s.countTask(task, -1)
+	task.status = status
+	s.countTask(task, 1)
+	task.record("status changed to %s", status)
+	if isTerminal(status) {
+		task.finishedAt = time.Now()
+	}
+	if ch, ok := s.subscribers[task.id]; ok {
+		// Never block while holding the lock; a subscriber that falls
+		// behind misses intermediate updates.
+		select {
+		case ch <- status:
+		default:
 		}
 	}
-	s.mu.Unlock()
 }
 
-// tracerProvider returns an OpenTelemetry TracerProvider configured to use
-// the Jaeger exporter.
-func tracerProvider(url string) (*tracesdk.TracerProvider, error) {
-	// Create the Jaeger exporter
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(url)))
-	if err != nil {
-		return nil, err
+// cancelTask stops a queued or running task and marks it CANCELLED.
+// It reports whether the task was cancelled. The caller must hold s.mu.
+func (s *server) cancelTask(task *task) bool {
+	if task.status != statusQueued && task.status != statusInProgress {
+		return false
+	}
+	if task.cancel != nil {
+		task.cancel()
+		task.cancel = nil
+	}
+	s.dequeue(task)
+	task.attempt++
+	s.setStatus(task, statusCancelled)
+	return true
+}
+
+// retryTask puts a failed or cancelled task back into the queue on behalf of
+// the call in ctx. It reports whether the task was requeued. The caller must
+// hold s.mu.
+func (s *server) retryTask(ctx context.Context, task *task) bool {
+	if task.status != statusFailed && task.status != statusCancelled {
+		return false
+	}
+	task.requeuedBy = trace.SpanContextFromContext(ctx)
+	task.attempt++
+	task.retries = 0
+	s.recordRetry(task, retryManual)
+	s.setStatus(task, statusQueued)
+	s.enqueue(task)
+	return true
+}
+
+// deleteTask stops a task if needed and removes it from the server, ending
+// any status streams for it. The caller must hold s.mu.
+func (s *server) deleteTask(task *task) {
+	if task.cancel != nil {
+		task.cancel()
+		task.cancel = nil
+	}
+	s.dequeue(task)
+	endExecution(task, "task deleted")
+	s.stopWaiting(task)
+	s.countTask(task, -1)
+	s.pruneOwnerStats(task)
+	delete(s.tasks, task.id)
+	s.unindexLabels(task)
+	if ch, ok := s.subscribers[task.id]; ok {
+		close(ch)
+		delete(s.subscribers, task.id)
+	}
+}
+
+// interceptors returns the options installing the interceptors of the gRPC
+// API: call logging, authentication, audit log and authorization.
+func (s *server) interceptors() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(loggingUnaryInterceptor, s.authUnaryInterceptor, s.auditUnaryInterceptor, authorizeUnaryInterceptor),
+		grpc.ChainStreamInterceptor(loggingStreamInterceptor, s.authStreamInterceptor, s.auditStreamInterceptor, authorizeStreamInterceptor),
 	}
-	tp := tracesdk.NewTracerProvider(
-		// Always be sure to batch in production.
-		tracesdk.WithBatcher(exp),
-		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceName("taskmanager-server"),
-		)),
-	)
-	return tp, nil
 }
 
 // main is the entry point for the server application.
-// It initializes the gRPC server and starts listening for incoming connections.
+// It loads the configuration, initializes the gRPC server and serves until
+// SIGINT or SIGTERM, then shuts down gracefully. SIGHUP reloads the settings
+// that can change without a restart.
 func main() {
-	// Initialize the server.
-	lis, err := net.Listen("tcp", ":50051")
+	opts, err := parseOptions(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		fatal("invalid options", "error", err)
+	}
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	if opts.printConfig {
+		if err := cfg.print(os.Stdout); err != nil {
+			fatal("failed to print configuration", "error", err)
+		}
+		return
+	}
+	if err := setupLogging(cfg.Log); err != nil {
+		fatal("failed to set up logging", "error", err)
 	}
 
-	// Initialize OpenTelemetry
-	tp, err := tracerProvider("http://jaeger:14268/api/traces")
+	// Initialize the server.
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
 	if err != nil {
-		log.Fatal(err)
+		fatal("failed to listen", "addr", cfg.GRPCAddr, "error", err)
 	}
-	otel.SetTracerProvider(tp)
 
-	// Create a new Prometheus exporter and register it as a provider.
+	// Initialize OpenTelemetry from the OTEL_* environment, always serving
+	// metrics to Prometheus.
 	exporter, err := prometheus.New()
 	if err != nil {
-		log.Fatal(err)
+		fatal("failed to create Prometheus exporter", "error", err)
+	}
+	providers, err := telemetry.Setup(context.Background(), "taskmanager-server", exporter)
+	if err != nil {
+		fatal("failed to set up telemetry", "error", err)
 	}
 
-	// Create a gRPC server with the OpenTelemetry interceptor.
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
-	)
-	pb.RegisterTaskManagerServer(grpcServer, newServer())
+	srv := newServer()
+	if err := srv.registerTaskMetrics(otel.Meter("taskmanager")); err != nil {
+		fatal("failed to register metrics", "error", err)
+	}
+	if err := srv.registerQuotaMetrics(otel.Meter("taskmanager")); err != nil {
+		fatal("failed to register metrics", "error", err)
+	}
+	if err := srv.registerAdmissionMetrics(otel.Meter("taskmanager")); err != nil {
+		fatal("failed to register metrics", "error", err)
+	}
+	if err := srv.registerRetentionMetrics(otel.Meter("taskmanager")); err != nil {
+		fatal("failed to register metrics", "error", err)
+	}
+	srv.applyConfig(cfg)
+	if err := srv.configureAuth(cfg.Auth); err != nil {
+		fatal("failed to configure authentication", "error", err)
+	}
+
+	if cfg.Audit.File != "" {
+		sink, err := newFileAuditSink(cfg.Audit)
+		if err != nil {
+			fatal("failed to open audit log", "file", cfg.Audit.File, "error", err)
+		}
+		srv.audit = sink
+	}
+
+	// Create a gRPC server with the OpenTelemetry instrumentation and the
+	// interceptors.
+	serverOpts := append([]grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}, srv.interceptors()...)
+	if cfg.TLS.enabled() {
+		certs, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			fatal("failed to load TLS certificates", "error", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(certs.serverConfig())))
+	}
+	grpcServer := grpc.NewServer(serverOpts...)
+	pb.RegisterTaskManagerServer(grpcServer, srv)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	if cfg.Reflection {
+		reflection.Register(grpcServer)
+	}
+	go srv.runReaper()
+	go srv.runHealth(healthServer)
 
 	// Start a separate HTTP server for metrics and health checks.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/livez", healthHandler(srv.liveness))
+	mux.Handle("/readyz", healthHandler(srv.readiness))
+	// The root answers like /readyz for load balancers that probe it.
+	mux.Handle("/", healthHandler(srv.readiness))
+	httpServer := &http.Server{Addr: cfg.HTTPAddr, Handler: mux}
 	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-		})
-		log.Fatal(http.ListenAndServe(":8080", nil))
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("failed to serve HTTP", "addr", cfg.HTTPAddr, "error", err)
+		}
 	}()
 
-	log.Println("gRPC server is running on port :50051")
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	// The debug endpoints are not authenticated and only served on a
+	// loopback address. Channelz reveals the connections of all callers,
+	// so it is served there too rather than on the gRPC API.
+	var debugServer *http.Server
+	if cfg.DebugAddr != "" {
+		channelzServer := grpc.NewServer()
+		channelzsvc.RegisterChannelzServiceToServer(channelzServer)
+		reflection.Register(channelzServer)
+		debugServer = newDebugServer(cfg.DebugAddr, srv.debugHandler(channelzServer))
+		go func() {
+			slog.Info("debug server is running", "addr", cfg.DebugAddr)
+			if err := debugServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("failed to serve debug endpoints", "addr", cfg.DebugAddr, "error", err)
+			}
+		}()
+	}
+
+	go func() {
+		slog.Info("gRPC server is running", "addr", cfg.GRPCAddr)
+		if err := grpcServer.Serve(lis); err != nil {
+			fatal("failed to serve", "error", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			slog.Info("shutting down", "signal", sig.String())
+			break
+		}
+		reloaded, err := loadConfig(opts)
+		if err == nil {
+			err = srv.configureAuth(reloaded.Auth)
+		}
+		if err != nil {
+			slog.Error("configuration not reloaded", "error", err)
+			continue
+		}
+		var ignored []string
+		cfg, ignored = reloaded.reloadable(cfg)
+		if len(ignored) > 0 {
+			slog.Warn("changes take effect after a restart", "settings", strings.Join(ignored, ", "))
+		}
+		srv.applyConfig(cfg)
+		slog.Info("configuration reloaded")
+	}
+
+	// Stop intake and let running tasks finish, then close the listeners.
+	srv.shutdown(cfg.Shutdown.GracePeriod, cfg.Shutdown.Policy)
+	grpcServer.GracefulStop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Error("failed to stop HTTP server", "error", err)
+	}
+	if debugServer != nil {
+		if err := debugServer.Close(); err != nil {
+			slog.Error("failed to stop debug server", "error", err)
+		}
+	}
+	if err := providers.Shutdown(ctx); err != nil {
+		slog.Error("failed to flush telemetry", "error", err)
+	}
+	if srv.audit != nil {
+		if err := srv.audit.close(); err != nil {
+			slog.Error("failed to close audit log", "error", err)
+		}
 	}
+	slog.Info("server stopped")
 }
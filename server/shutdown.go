@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Policies for tasks still running when the shutdown grace period ends.
+const (
+	// shutdownRequeue puts interrupted tasks back into their queue.
+	shutdownRequeue = "requeue"
+	// shutdownFail marks interrupted tasks as FAILED.
+	shutdownFail = "fail"
+)
+
+// beginShutdown stops the server from accepting and starting tasks. Running
+// tasks and the status streams following them are left alone.
+func (s *server) beginShutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown {
+		return
+	}
+	s.shuttingDown = true
+	close(s.stopping)
+}
+
+// waitForTasks waits until no task is running or the context is done. It
+// reports whether all tasks finished.
+func (s *server) waitForTasks(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// interruptTasks stops every running task and requeues or fails it according
+// to the policy. It returns the number of interrupted tasks.
+func (s *server) interruptTasks(policy string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interrupted := 0
+	for _, task := range s.tasks {
+		if task.status != statusInProgress {
+			continue
+		}
+		task.cancel()
+		task.cancel = nil
+		task.attempt++
+		task.record("interrupted by server shutdown")
+		if policy == shutdownFail {
+			s.setStatus(task, statusFailed)
+		} else {
+			s.setStatus(task, statusQueued)
+			s.enqueue(task)
+		}
+		interrupted++
+	}
+	return interrupted
+}
+
+// endStreams ends all status streams once no task will change its status
+// anymore. Streams still send the status their task was left in first.
+func (s *server) endStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.streamsDone:
+	default:
+		close(s.streamsDone)
+	}
+}
+
+// shutdown drains the server: intake stops, running tasks get up to the grace
+// period to finish and the rest are handled according to the policy. Status
+// streams stay open until every task has its final status for this run.
+func (s *server) shutdown(grace time.Duration, policy string) {
+	s.beginShutdown()
+	defer s.endStreams()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if s.waitForTasks(ctx) {
+		return
+	}
+	n := s.interruptTasks(policy)
+	slog.Warn("interrupted running tasks after grace period", "count", n, "grace_period", grace, "policy", policy)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blockingWork returns work that runs until release is closed or the task is
+// interrupted, and then completes.
+func blockingWork(release <-chan struct{}) func(context.Context, *task) string {
+	return func(ctx context.Context, t *task) string {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return statusCompleted
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		grace  time.Duration
+		policy string
+		// finish lets the task finish once the shutdown began.
+		finish bool
+		want   string
+		// streamed are the statuses the stream receives once the shutdown
+		// began, and streamCode the code it ends with.
+		streamed   []string
+		streamCode codes.Code
+	}{{
+		name:       "finished within grace period",
+		grace:      time.Minute,
+		policy:     shutdownFail,
+		finish:     true,
+		want:       statusCompleted,
+		streamed:   []string{statusCompleted},
+		streamCode: codes.OK,
+	}, {
+		name:       "failed after grace period",
+		grace:      20 * time.Millisecond,
+		policy:     shutdownFail,
+		want:       statusFailed,
+		streamed:   []string{statusFailed},
+		streamCode: codes.OK,
+	}, {
+		name:       "requeued after grace period",
+		grace:      20 * time.Millisecond,
+		policy:     shutdownRequeue,
+		want:       statusQueued,
+		streamed:   []string{statusQueued},
+		streamCode: codes.Unavailable,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer()
+			release := make(chan struct{})
+			s.work = blockingWork(release)
+			conn, _ := serve(t, s)
+			task := submit(t, s, "", "MEDIUM")
+
+			stream, err := pb.NewTaskManagerClient(conn).StreamTaskStatus(context.Background(), &pb.StatusRequest{TaskId: task.id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				res, err := stream.Recv()
+				if err != nil {
+					t.Fatalf("stream ended before the task started: %v", err)
+				}
+				if res.Status == statusInProgress {
+					break
+				}
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				s.shutdown(tt.grace, tt.policy)
+			}()
+			if tt.finish {
+				for !shuttingDown(s) {
+					time.Sleep(time.Millisecond)
+				}
+				close(release)
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("shutdown did not return")
+			}
+
+			if got := taskStatus(s, task); got != tt.want {
+				t.Errorf("task is %s, want %s", got, tt.want)
+			}
+			var streamed []string
+			for {
+				res, err := stream.Recv()
+				if err != nil {
+					if err == io.EOF {
+						err = nil
+					}
+					if status.Code(err) != tt.streamCode {
+						t.Errorf("stream ended with %v, want %v", err, tt.streamCode)
+					}
+					break
+				}
+				streamed = append(streamed, res.Status)
+			}
+			if !slices.Equal(streamed, tt.streamed) {
+				t.Errorf("stream received %q, want %q", streamed, tt.streamed)
+			}
+
+			// Nothing is accepted or started anymore.
+			if _, err := s.SubmitTask(context.Background(), &pb.TaskRequest{TaskDescription: "late"}); status.Code(err) != codes.Unavailable {
+				t.Errorf("SubmitTask after shutdown returned %v, want %v", err, codes.Unavailable)
+			}
+			if tt.want == statusQueued && taskStatus(s, task) != statusQueued {
+				t.Errorf("requeued task was started after shutdown")
+			}
+		})
+	}
+}
+
+// shuttingDown reports whether the server began to shut down.
+func shuttingDown(s *server) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// throughputStep is the resolution of the throughput windows, and
+	// throughputSpan the longest window.
+	throughputStep = 10 * time.Second
+	throughputSpan = time.Hour
+	// latencySamples is the number of recent durations the latency
+	// percentiles are computed from.
+	latencySamples = 1000
+)
+
+// throughputWindows are the windows finished tasks are reported over.
+var throughputWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// countKey groups the tasks counted by taskStats.
+type countKey struct {
+	queue, priority, status string
+}
+
+// taskStats holds the statistics of a group of tasks. They are updated as
+// tasks change, so GetStatistics does not have to look at every task.
+type taskStats struct {
+	counts map[countKey]int
+	// finished counts the tasks reaching a final status, by status.
+	finished map[string]*eventWindow
+	// queueWait and runDuration hold the most recent durations tasks waited
+	// and ran.
+	queueWait   sampleRing
+	runDuration sampleRing
+	// waiting holds the time every QUEUED task entered that status, oldest
+	// first.
+	waiting list.List
+	history history
+}
+
+func newTaskStats() *taskStats {
+	return &taskStats{
+		counts: make(map[countKey]int),
+		finished: map[string]*eventWindow{
+			statusCompleted: {},
+			statusFailed:    {},
+			statusCancelled: {},
+		},
+	}
+}
+
+// eventWindow counts events in steps of throughputStep over the last
+// throughputSpan.
+type eventWindow struct {
+	buckets [throughputSpan / throughputStep]int
+	// last is the step of the most recent event.
+	last int64
+}
+
+// step returns the number of the step the time falls in.
+func step(t time.Time) int64 {
+	return t.UnixNano() / int64(throughputStep)
+}
+
+// add counts an event at now.
+func (w *eventWindow) add(now time.Time) {
+	n := step(now)
+	w.advance(n)
+	w.buckets[n%int64(len(w.buckets))]++
+}
+
+// advance clears the buckets of the steps after the last event up to n.
+func (w *eventWindow) advance(n int64) {
+	if n <= w.last {
+		return
+	}
+	for i := max(w.last+1, n-int64(len(w.buckets))+1); i <= n; i++ {
+		w.buckets[i%int64(len(w.buckets))] = 0
+	}
+	w.last = n
+}
+
+// count returns the number of events within d before now.
+func (w *eventWindow) count(now time.Time, d time.Duration) int {
+	n := step(now)
+	total := 0
+	for i := n - int64(d/throughputStep) + 1; i <= n; i++ {
+		if i > w.last || i <= w.last-int64(len(w.buckets)) {
+			continue
+		}
+		total += w.buckets[i%int64(len(w.buckets))]
+	}
+	return total
+}
+
+// sampleRing keeps the last latencySamples durations.
+type sampleRing struct {
+	samples []time.Duration
+	next    int
+}
+
+// add records a duration, replacing the oldest one once the ring is full.
+func (r *sampleRing) add(d time.Duration) {
+	if len(r.samples) < latencySamples {
+		r.samples = append(r.samples, d)
+		return
+	}
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % latencySamples
+}
+
+// proto returns the percentiles of the durations in the ring.
+func (r *sampleRing) proto() *pb.LatencyPercentiles {
+	res := &pb.LatencyPercentiles{Samples: int32(len(r.samples))}
+	if len(r.samples) == 0 {
+		return res
+	}
+	sorted := slices.Clone(r.samples)
+	slices.Sort(sorted)
+	percentile := func(p int) *durationpb.Duration {
+		// The nearest-rank method: the smallest duration such that p
+		// percent of the samples are no longer.
+		i := (p*len(sorted)+99)/100 - 1
+		return durationpb.New(sorted[max(i, 0)])
+	}
+	res.P50, res.P95, res.P99 = percentile(50), percentile(95), percentile(99)
+	return res
+}
+
+// statsOf returns the statistics the task is counted in: those of its tenant
+// and those of its owner within the tenant. The caller must hold s.mu.
+func (s *server) statsOf(t *task) []*taskStats {
+	tn := s.tenants[t.tenant]
+	if t.owner == "" {
+		return []*taskStats{tn.stats}
+	}
+	st, ok := tn.ownerStats[t.owner]
+	if !ok {
+		st = newTaskStats()
+		tn.ownerStats[t.owner] = st
+	}
+	return []*taskStats{tn.stats, st}
+}
+
+// pruneOwnerStats drops the statistics of the owner of a deleted task once
+// none of its tasks are left, so principals that stopped submitting do not
+// keep their statistics forever. The caller must hold s.mu.
+func (s *server) pruneOwnerStats(t *task) {
+	tn := s.tenants[t.tenant]
+	if st, ok := tn.ownerStats[t.owner]; ok && len(st.counts) == 0 {
+		delete(tn.ownerStats, t.owner)
+	}
+}
+
+// callerStats returns the statistics of the tasks of the tenant the caller may
+// see, or nil when it has not submitted any. The caller must hold s.mu.
+func callerStats(ctx context.Context, tn *tenant) *taskStats {
+	p, ok := principalFromContext(ctx)
+	if !ok || p.can(permViewAll) {
+		return tn.stats
+	}
+	return tn.ownerStats[p.name]
+}
+
+// countStats adds delta to the number of tasks in the queue, priority and
+// status of the task. The caller must hold s.mu.
+func (s *server) countStats(t *task, delta int) {
+	key := countKey{queue: t.queue, priority: t.priority, status: t.status}
+	for _, st := range s.statsOf(t) {
+		st.counts[key] += delta
+		if st.counts[key] == 0 {
+			delete(st.counts, key)
+		}
+	}
+}
+
+// recordStats records the statistics of a task moving to a new status. The
+// caller must hold s.mu.
+func (s *server) recordStats(t *task, status string, now time.Time) {
+	for _, st := range s.statsOf(t) {
+		if status == statusInProgress && t.status != statusInProgress {
+			st.queueWait.add(t.startedAt.Sub(t.queuedAt))
+		}
+		if t.status == statusInProgress && status != statusInProgress {
+			st.runDuration.add(now.Sub(t.startedAt))
+		}
+		if w, ok := st.finished[status]; ok && !isTerminal(t.status) {
+			w.add(now)
+		}
+		st.recordHistory(t, status, now)
+	}
+	if t.status == statusQueued && status != statusQueued {
+		s.stopWaiting(t)
+	}
+	if status == statusQueued && t.status != statusQueued {
+		s.startWaiting(t, now)
+	}
+}
+
+// startWaiting adds a task that entered the QUEUED status to the waiting
+// tasks of its statistics. The caller must hold s.mu.
+func (s *server) startWaiting(t *task, now time.Time) {
+	for _, st := range s.statsOf(t) {
+		t.waiting = append(t.waiting, st.waiting.PushBack(now))
+	}
+}
+
+// stopWaiting removes a task from the waiting tasks of its statistics, if it
+// is there. The caller must hold s.mu.
+func (s *server) stopWaiting(t *task) {
+	for i, st := range s.statsOf(t) {
+		if i < len(t.waiting) {
+			st.waiting.Remove(t.waiting[i])
+		}
+	}
+	t.waiting = nil
+}
+
+// proto fills the counts, throughput and latencies of the statistics into the
+// response, adding groups missing from its ByQueue and ByPriority maps.
+func (st *taskStats) proto(stats *pb.StatisticsResponse, now time.Time) {
+	total := &pb.StatusCounts{}
+	for key, n := range st.counts {
+		countStatus(total, key.status, n)
+		counts, ok := stats.ByQueue[key.queue]
+		if !ok {
+			counts = &pb.StatusCounts{}
+			stats.ByQueue[key.queue] = counts
+		}
+		countStatus(counts, key.status, n)
+		counts, ok = stats.ByPriority[key.priority]
+		if !ok {
+			counts = &pb.StatusCounts{}
+			stats.ByPriority[key.priority] = counts
+		}
+		countStatus(counts, key.status, n)
+	}
+	stats.Queued, stats.InProgress = total.Queued, total.InProgress
+	stats.Completed, stats.Failed, stats.Cancelled = total.Completed, total.Failed, total.Cancelled
+
+	for _, window := range throughputWindows {
+		tp := &pb.Throughput{
+			Window:    durationpb.New(window),
+			Completed: int32(st.finished[statusCompleted].count(now, window)),
+			Failed:    int32(st.finished[statusFailed].count(now, window)),
+			Cancelled: int32(st.finished[statusCancelled].count(now, window)),
+		}
+		tp.PerSecond = float64(tp.Completed+tp.Failed+tp.Cancelled) / window.Seconds()
+		stats.Throughput = append(stats.Throughput, tp)
+	}
+
+	stats.QueueWait = st.queueWait.proto()
+	stats.RunDuration = st.runDuration.proto()
+	if oldest := st.waiting.Front(); oldest != nil {
+		stats.OldestQueuedAge = durationpb.New(now.Sub(oldest.Value.(time.Time)))
+	}
+}
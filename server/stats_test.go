@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+)
+
+func TestOwnerStatsPruned(t *testing.T) {
+	s := newServer()
+	s.tenants[defaultTenant].queues[defaultQueue].paused = true
+
+	submitAs := func(name string) *task {
+		t.Helper()
+		res, err := s.SubmitTask(as(name, defaultTenant, roleSubmitter), &pb.TaskRequest{TaskDescription: "test"})
+		if err != nil {
+			t.Fatalf("SubmitTask as %s: %v", name, err)
+		}
+		return s.tasks[res.TaskId]
+	}
+	alice1, alice2, bob := submitAs("alice"), submitAs("alice"), submitAs("bob")
+	owners := func() map[string]bool {
+		res := make(map[string]bool)
+		for name := range s.tenants[defaultTenant].ownerStats {
+			res[name] = true
+		}
+		return res
+	}
+	if got := owners(); !got["alice"] || !got["bob"] {
+		t.Fatalf("statistics kept for %v, want alice and bob", got)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Finished tasks still count.
+	s.cancelTask(bob)
+	if got := owners(); !got["bob"] {
+		t.Errorf("statistics of bob dropped while the task is kept")
+	}
+	s.deleteTask(bob)
+	if got := owners(); got["bob"] {
+		t.Errorf("statistics of bob kept after the last task was deleted")
+	}
+
+	s.deleteTask(alice1)
+	if got := owners(); !got["alice"] {
+		t.Errorf("statistics of alice dropped while a task is left")
+	}
+	s.deleteTask(alice2)
+	if got := owners(); len(got) != 0 {
+		t.Errorf("statistics kept for %v after all tasks were deleted", got)
+	}
+
+	// The tenant statistics are left alone.
+	if n := len(s.tenants[defaultTenant].stats.counts); n != 0 {
+		t.Errorf("tenant statistics count %d groups of tasks, want none", n)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// defaultTenant is the tenant of callers whose credentials name none, and of
+// every caller while authentication is off. It always exists.
+const defaultTenant = "default"
+
+// tenantConfig holds the limits of a tenant.
+type tenantConfig struct {
+	// maxConcurrency limits the number of running tasks across all queues
+	// of the tenant; 0 means unlimited.
+	maxConcurrency int
+	// maxPriority is the highest priority tasks may have; empty means no
+	// ceiling.
+	maxPriority string
+	// retention overrides the TTLs of the server retention; zero durations
+	// fall back to it.
+	retention retentionConfig
+	quota     quotaConfig
+}
+
+// tenant is an isolated namespace with its own queues and limits.
+type tenant struct {
+	name    string
+	config  tenantConfig
+	queues  map[string]*queue
+	running int
+	// counts is the number of tasks of the tenant in each status.
+	counts map[string]int
+	// stats are the statistics of all tasks of the tenant, and ownerStats
+	// those of the tasks of each principal that has tasks left.
+	stats      *taskStats
+	ownerStats map[string]*taskStats
+	// limiter and callerLimiters are the token buckets of the tenant and
+	// of each of its callers; nil when there is no rate limit. The buckets
+	// of idle callers are dropped, callersSwept is when that was last done.
+	limiter        *rate.Limiter
+	callerLimiters map[string]*rate.Limiter
+	callersSwept   time.Time
+	// rejected counts the submissions rejected by the quota, by reason.
+	rejected map[string]int64
+	// labelIndex maps label key and value to the IDs of the tasks of the
+	// tenant carrying that label.
+	labelIndex map[string]map[string]map[string]struct{}
+}
+
+// newTenant creates a tenant with an empty default queue.
+func newTenant(name string, config tenantConfig) *tenant {
+	tn := &tenant{
+		name:       name,
+		config:     config,
+		queues:     make(map[string]*queue),
+		counts:     make(map[string]int),
+		stats:      newTaskStats(),
+		ownerStats: make(map[string]*taskStats),
+		rejected:   make(map[string]int64),
+		labelIndex: make(map[string]map[string]map[string]struct{}),
+	}
+	tn.queues[defaultQueue] = newQueue(tn, defaultQueue, queueConfig{})
+	tn.setQuota(config.quota)
+	return tn
+}
+
+// hasCapacity reports whether another task of the tenant may be started.
+func (tn *tenant) hasCapacity() bool {
+	return tn.config.maxConcurrency <= 0 || tn.running < tn.config.maxConcurrency
+}
+
+// allows reports whether tasks of the tenant may have the priority.
+func (tn *tenant) allows(priority string) bool {
+	return tn.config.maxPriority == "" || priorityRank(priority) >= priorityRank(tn.config.maxPriority)
+}
+
+// checkPriority returns an InvalidArgument error if the priority is above the
+// ceiling of the tenant.
+func (tn *tenant) checkPriority(priority string) error {
+	if !tn.allows(priority) {
+		return status.Errorf(codes.InvalidArgument, "priority %s is above the ceiling %s of tenant %q", priority, tn.config.maxPriority, tn.name)
+	}
+	return nil
+}
+
+// queueByName returns the named queue of the tenant or a NotFound error.
+// The caller must hold the server lock.
+func (tn *tenant) queueByName(name string) (*queue, error) {
+	q, exists := tn.queues[name]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "queue %q not found", name)
+	}
+	return q, nil
+}
+
+// proto converts the tenant for the API. The caller must hold the server lock.
+func (tn *tenant) proto() *pb.Tenant {
+	res := &pb.Tenant{
+		Name:           tn.name,
+		MaxConcurrency: int32(tn.config.maxConcurrency),
+		MaxPriority:    tn.config.maxPriority,
+		Retention: &pb.TenantRetention{
+			Completed: durationpb.New(tn.config.retention.Completed),
+			Failed:    durationpb.New(tn.config.retention.Failed),
+			Cancelled: durationpb.New(tn.config.retention.Cancelled),
+		},
+		InProgress: int32(tn.running),
+		Quota:      tn.config.quota.proto(),
+	}
+	for _, q := range tn.queues {
+		res.Queued += int32(q.pending.Len())
+	}
+	return res
+}
+
+// tenantName returns the tenant of the caller.
+func tenantName(ctx context.Context) string {
+	if p, ok := principalFromContext(ctx); ok {
+		return p.tenant
+	}
+	return defaultTenant
+}
+
+// callerTenant returns the tenant of the caller, or a PermissionDenied error
+// when the tenant named by its credentials does not exist.
+// The caller must hold s.mu.
+func (s *server) callerTenant(ctx context.Context) (*tenant, error) {
+	name := tenantName(ctx)
+	tn, exists := s.tenants[name]
+	if !exists {
+		return nil, status.Errorf(codes.PermissionDenied, "tenant %q does not exist", name)
+	}
+	return tn, nil
+}
+
+// countTask adds delta to the number of tasks of the tenant in the status of
+// the task, and to its statistics. The caller must hold s.mu.
+func (s *server) countTask(t *task, delta int) {
+	s.tenants[t.tenant].counts[t.status] += delta
+	s.countStats(t, delta)
+}
+
+// retentionFor returns how long the finished task is kept, taking the
+// retention of its tenant before the server one. The caller must hold s.mu.
+func (s *server) retentionFor(t *task) time.Duration {
+	if ttl := s.tenants[t.tenant].config.retention.ttl(t.status); ttl > 0 {
+		return ttl
+	}
+	return s.retention.ttl(t.status)
+}
+
+// tenantConfigFromProto validates the configuration part of a tenant received
+// over the API.
+func tenantConfigFromProto(tn *pb.Tenant) (tenantConfig, error) {
+	config := tenantConfig{
+		maxConcurrency: int(tn.GetMaxConcurrency()),
+		maxPriority:    tn.GetMaxPriority(),
+		retention: retentionConfig{
+			Completed: tn.GetRetention().GetCompleted().AsDuration(),
+			Failed:    tn.GetRetention().GetFailed().AsDuration(),
+			Cancelled: tn.GetRetention().GetCancelled().AsDuration(),
+		},
+		quota: quotaConfigFromProto(tn.GetQuota()),
+	}
+	if config.maxConcurrency < 0 {
+		return config, status.Error(codes.InvalidArgument, "max_concurrency must not be negative")
+	}
+	if config.maxPriority != "" && !validPriority(config.maxPriority) {
+		return config, status.Errorf(codes.InvalidArgument, "invalid max priority %q", config.maxPriority)
+	}
+	r := config.retention
+	if r.Completed < 0 || r.Failed < 0 || r.Cancelled < 0 {
+		return config, status.Error(codes.InvalidArgument, "retention must not be negative")
+	}
+	if err := config.quota.validate(); err != nil {
+		return config, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return config, nil
+}
+
+// CreateTenant creates a new tenant with an empty default queue.
+func (s *server) CreateTenant(ctx context.Context, req *pb.CreateTenantRequest) (*pb.Tenant, error) {
+	name := req.GetTenant().GetName()
+	if !queueNamePattern.MatchString(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tenant name %q", name)
+	}
+	config, err := tenantConfigFromProto(req.GetTenant())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tenants[name]; exists {
+		return nil, status.Errorf(codes.AlreadyExists, "tenant %q already exists", name)
+	}
+	tn := newTenant(name, config)
+	s.tenants[name] = tn
+	return tn.proto(), nil
+}
+
+// ListTenants returns all tenants sorted by name.
+func (s *server) ListTenants(ctx context.Context, req *pb.ListTenantsRequest) (*pb.ListTenantsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := &pb.ListTenantsResponse{}
+	for _, tn := range s.tenants {
+		res.Tenants = append(res.Tenants, tn.proto())
+	}
+	slices.SortFunc(res.Tenants, func(a, b *pb.Tenant) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res, nil
+}
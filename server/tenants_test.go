@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusStream records the updates sent by StreamTaskStatus.
+type statusStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*pb.StatusResponse
+}
+
+func (s *statusStream) Context() context.Context { return s.ctx }
+
+func (s *statusStream) Send(r *pb.StatusResponse) error {
+	s.sent = append(s.sent, r)
+	return nil
+}
+
+func TestTenantIsolation(t *testing.T) {
+	s := newServer()
+	s.tenants["acme"] = newTenant("acme", tenantConfig{})
+	s.tenants["globex"] = newTenant("globex", tenantConfig{})
+	for _, tn := range s.tenants {
+		tn.queues[defaultQueue].paused = true
+	}
+	res, err := s.SubmitTask(as("alice", "acme", roleSubmitter), &pb.TaskRequest{TaskDescription: "test", Labels: map[string]string{"team": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := s.tasks[res.TaskId]
+	// The other tenant has a task with the same labels, so filters match
+	// something there.
+	if _, err := s.SubmitTask(as("bob", "globex", roleSubmitter), &pb.TaskRequest{TaskDescription: "test", Labels: map[string]string{"team": "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Even the most privileged callers of another tenant see the task as
+	// if it did not exist.
+	for _, ctx := range []context.Context{
+		as("bob", "globex", roleSubmitter),
+		as("root", "globex", roleAdmin),
+		as("ops", "globex", roleOperator, roleAuditor),
+	} {
+		p, _ := principalFromContext(ctx)
+		check, err := s.CheckTaskStatus(ctx, &pb.StatusRequest{TaskId: task.id})
+		if err != nil || check.Status != "UNKNOWN TASK" || len(check.History) != 0 {
+			t.Errorf("%v: CheckTaskStatus = %v, %v, want an unknown task", p.roles, check, err)
+		}
+
+		stream := &statusStream{ctx: ctx}
+		errTask := s.StreamTaskStatus(&pb.StatusRequest{TaskId: task.id}, stream)
+		errUnknown := s.StreamTaskStatus(&pb.StatusRequest{TaskId: "42"}, &statusStream{ctx: ctx})
+		if errTask == nil || errTask.Error() != errUnknown.Error() || len(stream.sent) != 0 {
+			t.Errorf("%v: StreamTaskStatus returned %v after %d updates, want %v", p.roles, errTask, len(stream.sent), errUnknown)
+		}
+
+		update := &pb.UpdateTaskRequest{TaskId: task.id, Priority: "HIGH", UpdateMask: mask(updatePriority)}
+		if _, err := s.UpdateTask(ctx, update); status.Code(err) != codes.NotFound {
+			t.Errorf("%v: UpdateTask returned %v, want %v", p.roles, err, codes.NotFound)
+		}
+
+		for _, filter := range []*pb.TaskFilter{nil, {Labels: map[string]string{"team": "a"}}} {
+			bulk := &bulkStream{ctx: ctx}
+			if err := s.BulkOperation(&pb.BulkOperationRequest{Action: bulkCancel, Filter: filter}, bulk); err != nil {
+				if status.Code(err) != codes.PermissionDenied {
+					t.Errorf("%v: BulkOperation: %v", p.roles, err)
+				}
+				continue
+			}
+			// Only the task of the caller's own tenant is matched.
+			if last := bulk.sent[len(bulk.sent)-1]; last.Matched != 1 {
+				t.Errorf("%v: BulkOperation matched %d tasks, want 1", p.roles, last.Matched)
+			}
+		}
+	}
+	if task.status != statusQueued || task.priority != "MEDIUM" {
+		t.Errorf("task is %s %s after calls from another tenant, want %s MEDIUM", task.status, task.priority, statusQueued)
+	}
+	if n := s.tenants["globex"].counts[statusCancelled]; n != 1 {
+		t.Errorf("globex has %d cancelled tasks, want its own task cancelled", n)
+	}
+}
+
+func TestTenantLimitsIndependent(t *testing.T) {
+	s := newServer()
+	release := make(chan struct{})
+	defer close(release)
+	s.work = blockingWork(release)
+	s.tenants["acme"] = newTenant("acme", tenantConfig{maxConcurrency: 1, quota: quotaConfig{MaxInFlight: 3}})
+	s.tenants["globex"] = newTenant("globex", tenantConfig{maxConcurrency: 2, quota: quotaConfig{MaxInFlight: 4}})
+
+	submitTo := func(tenant string) error {
+		_, err := s.SubmitTask(as("alice", tenant, roleSubmitter), &pb.TaskRequest{TaskDescription: "test"})
+		return err
+	}
+	// acme reaches its quota first; globex keeps accepting up to its own.
+	for i, want := range []struct {
+		tenant string
+		code   codes.Code
+	}{
+		{"acme", codes.OK},
+		{"acme", codes.OK},
+		{"acme", codes.OK},
+		{"acme", codes.ResourceExhausted},
+		{"globex", codes.OK},
+		{"globex", codes.OK},
+		{"globex", codes.OK},
+		{"globex", codes.OK},
+		{"globex", codes.ResourceExhausted},
+		{defaultTenant, codes.OK},
+	} {
+		if err := submitTo(want.tenant); status.Code(err) != want.code {
+			t.Errorf("submission %d to %s returned %v, want %v", i, want.tenant, err, want.code)
+		}
+	}
+
+	// Every tenant runs up to its own concurrency limit.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, want := range map[string]struct{ running, queued int }{
+		"acme":        {1, 2},
+		"globex":      {2, 2},
+		defaultTenant: {1, 0},
+	} {
+		tn := s.tenants[name]
+		if tn.running != want.running || tn.counts[statusQueued] != want.queued {
+			t.Errorf("%s has %d running and %d queued tasks, want %d and %d", name, tn.running, tn.counts[statusQueued], want.running, want.queued)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes. Checks only happen while handshakes take place.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the server certificate and the client CA bundle from
+// files, loading them again when the files change so certificates can be
+// rotated without a restart.
+type certReloader struct {
+	certFile, keyFile, caFile string
+
+	mu       sync.Mutex
+	config   *tls.Config
+	modTimes []time.Time
+	checked  time.Time
+}
+
+// newCertReloader loads the certificate, key and, when caFile is set, the CA
+// bundle used to verify client certificates.
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files returns the files the reloader watches.
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+// reload reads all files and builds a new TLS configuration from them.
+func (r *certReloader) reload() error {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", r.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = config
+	r.modTimes = modTimes
+	r.checked = time.Now()
+	return nil
+}
+
+// statFiles returns the modification times of the watched files.
+func (r *certReloader) statFiles() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+// current returns the TLS configuration, reloading it first when the files
+// have changed since they were last checked. A failed reload keeps the
+// previous configuration so a half-written rotation does not break the server.
+func (r *certReloader) current() *tls.Config {
+	r.mu.Lock()
+	config, checked, modTimes := r.config, r.checked, r.modTimes
+	if time.Since(checked) < certCheckInterval {
+		r.mu.Unlock()
+		return config
+	}
+	r.checked = time.Now()
+	r.mu.Unlock()
+
+	latest, err := r.statFiles()
+	if err != nil || slices.EqualFunc(latest, modTimes, time.Time.Equal) {
+		return config
+	}
+	if err := r.reload(); err != nil {
+		slog.Error("keeping previous TLS certificates", "error", err)
+		return config
+	}
+	slog.Info("TLS certificates reloaded")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config
+}
+
+// serverConfig returns a TLS configuration that picks up the current
+// certificates on every handshake.
+func (r *certReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
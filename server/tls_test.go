@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a new self-signed certificate with the common name and its
+// key to the files, with the given modification time.
+func writeCert(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+}
+
+// writeFile writes the file with the given modification time.
+func writeFile(t *testing.T, name string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// certName returns the common name of the certificate of the configuration.
+func certName(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+// servedName returns the common name of the certificate the reloader serves
+// once the check interval has passed.
+func servedName(t *testing.T, r *certReloader) string {
+	t.Helper()
+	r.mu.Lock()
+	r.checked = time.Time{}
+	r.mu.Unlock()
+	config, err := r.serverConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certName(t, config)
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, "first", start)
+
+	r, err := newCertReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedName(t, r); got != "first" {
+		t.Fatalf("serving %q, want first", got)
+	}
+
+	// Within the check interval the files are not looked at.
+	writeCert(t, certFile, keyFile, "second", start.Add(time.Minute))
+	if got := certName(t, r.current()); got != "first" {
+		t.Errorf("serving %q within the check interval, want first", got)
+	}
+	if got := servedName(t, r); got != "second" {
+		t.Errorf("serving %q after the files changed, want second", got)
+	}
+
+	// A rotation that is only half done keeps the previous certificate:
+	// first a certificate without its new key, then a cut off certificate.
+	other := t.TempDir()
+	writeCert(t, filepath.Join(other, "server.pem"), filepath.Join(other, "server.key"), "third", start)
+	data, err := os.ReadFile(filepath.Join(other, "server.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, data, start.Add(2*time.Minute))
+	if got := servedName(t, r); got != "second" {
+		t.Errorf("serving %q with a certificate that does not match the key, want second", got)
+	}
+	writeFile(t, certFile, data[:len(data)/2], start.Add(3*time.Minute))
+	if got := servedName(t, r); got != "second" {
+		t.Errorf("serving %q with a cut off certificate, want second", got)
+	}
+
+	// Once the rotation is complete, the new certificate is served.
+	writeCert(t, certFile, keyFile, "third", start.Add(4*time.Minute))
+	if got := servedName(t, r); got != "third" {
+		t.Errorf("serving %q after the rotation completed, want third", got)
+	}
+
+	// Files that are gone keep the previous certificate as well.
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedName(t, r); got != "third" {
+		t.Errorf("serving %q without a key file, want third", got)
+	}
+}
+
+func TestCertReloaderRequiresClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem")
+	writeCert(t, certFile, keyFile, "server", time.Now())
+
+	writeFile(t, caFile, []byte("not a certificate"), time.Now())
+	if _, err := newCertReloader(certFile, keyFile, caFile); err == nil {
+		t.Error("loading a CA bundle without certificates succeeded")
+	}
+
+	ca, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, caFile, ca, time.Now())
+	r, err := newCertReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config := r.current(); config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Errorf("client authentication %v with CAs %v, want client certificates required", config.ClientAuth, config.ClientCAs)
+	}
+}
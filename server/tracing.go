@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of task execution.
+var tracer = otel.Tracer("taskmanager")
+
+// taskSpanAttributes returns the attributes describing a task on its spans.
+func taskSpanAttributes(t *task) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("task.id", t.id),
+		attribute.String("task.tenant", t.tenant),
+		attribute.String("task.queue", t.queue),
+		attribute.String("task.priority", t.priority),
+		attribute.Int("task.attempt", t.attempt),
+	)
+}
+
+// startExecution starts the spans of a task that leaves its queue: a
+// "task.queued" span covering the time it waited and a "task.execute" span
+// for the attempt, both children of the SubmitTask call so the work shows up
+// in its trace. An attempt queued again by another call, such as a bulk
+// retry, is linked to that call. It returns a context carrying the execute
+// span for the attempt. The caller must hold s.mu.
+func (s *server) startExecution(t *task) context.Context {
+	parent := trace.ContextWithRemoteSpanContext(context.Background(), t.spanContext)
+
+	_, wait := tracer.Start(parent, "task.queued", taskSpanAttributes(t), trace.WithTimestamp(t.queuedAt))
+	wait.End(trace.WithTimestamp(t.startedAt))
+
+	opts := []trace.SpanStartOption{taskSpanAttributes(t), trace.WithTimestamp(t.startedAt)}
+	if t.requeuedBy.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: t.requeuedBy}))
+		t.requeuedBy = trace.SpanContext{}
+	}
+	ctx, span := tracer.Start(parent, "task.execute", opts...)
+	t.span = span
+	return ctx
+}
+
+// traceStatus records a status change of a task as an event on its execute
+// span, and ends the span once the attempt is over. The caller must hold s.mu.
+func traceStatus(t *task, status string) {
+	if t.span == nil {
+		return
+	}
+	t.span.AddEvent("status changed", trace.WithAttributes(attribute.String("task.status", status)))
+	if status == statusInProgress {
+		return
+	}
+	if status == statusFailed {
+		t.span.SetStatus(otelcodes.Error, "task failed")
+	}
+	t.span.End()
+	t.span = nil
+}
+
+// endExecution ends the execute span of a task that is removed while
+// running. The caller must hold s.mu.
+func endExecution(t *task, reason string) {
+	if t.span == nil {
+		return
+	}
+	t.span.AddEvent(reason)
+	t.span.End()
+	t.span = nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spanExporter     = tracetest.NewInMemoryExporter()
+	spanExporterOnce sync.Once
+)
+
+// recordSpans installs a tracer provider that keeps the ended spans in
+// memory. The provider is global and can only be installed once, so tests
+// pick their spans by trace ID.
+func recordSpans() trace.Tracer {
+	spanExporterOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+	})
+	return otel.Tracer("test")
+}
+
+// spansOf returns the ended spans of the trace with the name.
+func spansOf(traceID trace.TraceID, name string) []tracetest.SpanStub {
+	var spans []tracetest.SpanStub
+	for _, span := range spanExporter.GetSpans() {
+		if span.SpanContext.TraceID() == traceID && span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestTaskSpans(t *testing.T) {
+	tracer := recordSpans()
+	s := newServer()
+	s.work = func(ctx context.Context, t *task) string {
+		if t.description == "fail" {
+			return statusFailed
+		}
+		return statusCompleted
+	}
+
+	tests := []struct {
+		description string
+		want        string
+		code        otelcodes.Code
+	}{
+		{"pass", statusCompleted, otelcodes.Unset},
+		{"fail", statusFailed, otelcodes.Error},
+	}
+	for _, tt := range tests {
+		ctx, call := tracer.Start(as("alice", defaultTenant, roleSubmitter), "SubmitTask")
+		res, err := s.SubmitTask(ctx, &pb.TaskRequest{TaskDescription: tt.description})
+		call.End()
+		if err != nil {
+			t.Fatal(err)
+		}
+		task := s.tasks[res.TaskId]
+		waitForStatus(t, s, task, tt.want)
+
+		// The spans of the task are children of the SubmitTask call.
+		parent := call.SpanContext()
+		queued, execute := spansOf(parent.TraceID(), "task.queued"), spansOf(parent.TraceID(), "task.execute")
+		if len(queued) != 1 || len(execute) != 1 {
+			t.Fatalf("%s: %d queued and %d execute spans, want one of each", tt.description, len(queued), len(execute))
+		}
+		for _, span := range []tracetest.SpanStub{queued[0], execute[0]} {
+			if span.Parent.SpanID() != parent.SpanID() {
+				t.Errorf("%s: %s span has parent %s, want %s", tt.description, span.Name, span.Parent.SpanID(), parent.SpanID())
+			}
+			if !slices.Contains(span.Attributes, attribute.String("task.id", task.id)) {
+				t.Errorf("%s: %s span attributes %v, want the task ID", tt.description, span.Name, span.Attributes)
+			}
+		}
+		if !queued[0].EndTime.Equal(execute[0].StartTime) {
+			t.Errorf("%s: queued span ends at %s, want the start of the execution %s", tt.description, queued[0].EndTime, execute[0].StartTime)
+		}
+		var statuses []string
+		for _, event := range execute[0].Events {
+			for _, attr := range event.Attributes {
+				if attr.Key == "task.status" {
+					statuses = append(statuses, attr.Value.AsString())
+				}
+			}
+		}
+		if !slices.Equal(statuses, []string{statusInProgress, tt.want}) {
+			t.Errorf("%s: execute span recorded statuses %v, want %s and %s", tt.description, statuses, statusInProgress, tt.want)
+		}
+		if execute[0].Status.Code != tt.code {
+			t.Errorf("%s: execute span status %v, want %v", tt.description, execute[0].Status.Code, tt.code)
+		}
+	}
+}
+
+func TestTaskSpansOfRequeuedTask(t *testing.T) {
+	tracer := recordSpans()
+	s := newServer()
+	s.tenants[defaultTenant].queues[defaultQueue].paused = true
+	s.work = func(ctx context.Context, t *task) string { return statusCompleted }
+
+	ctx, submitCall := tracer.Start(context.Background(), "SubmitTask")
+	res, err := s.SubmitTask(ctx, &pb.TaskRequest{TaskDescription: "test"})
+	submitCall.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := s.tasks[res.TaskId]
+	if err := s.BulkOperation(&pb.BulkOperationRequest{Action: bulkCancel}, &bulkStream{ctx: context.Background()}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, retryCall := tracer.Start(context.Background(), "BulkOperation")
+	err = s.BulkOperation(&pb.BulkOperationRequest{Action: bulkRetry}, &bulkStream{ctx: ctx})
+	retryCall.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ResumeQueue(context.Background(), &pb.QueueRequest{Name: defaultQueue}); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, s, task, statusCompleted)
+
+	// The attempt stays in the trace of the submission and is linked to the
+	// call that queued it again.
+	parent := submitCall.SpanContext()
+	execute := spansOf(parent.TraceID(), "task.execute")
+	if len(execute) != 1 {
+		t.Fatalf("%d execute spans, want 1", len(execute))
+	}
+	if execute[0].Parent.SpanID() != parent.SpanID() {
+		t.Errorf("execute span has parent %s, want %s", execute[0].Parent.SpanID(), parent.SpanID())
+	}
+	if len(execute[0].Links) != 1 || !execute[0].Links[0].SpanContext.Equal(retryCall.SpanContext()) {
+		t.Errorf("execute span links %v, want the retrying call %s", execute[0].Links, retryCall.SpanContext().SpanID())
+	}
+}
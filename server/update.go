@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Fields of UpdateTaskRequest that can be named in its update mask.
+const (
+	updateDescription = "task_description"
+	updatePriority    = "priority"
+	updateLabels      = "labels"
+	updateQueue       = "queue"
+)
+
+// UpdateTask changes the fields named in the update mask of a task that is
+// still QUEUED, and can move it to another queue of its tenant. Tasks in any
+// other status are rejected with codes.FailedPrecondition. Every change is
+// recorded in the task's history.
+func (s *server) UpdateTask(ctx context.Context, req *pb.UpdateTaskRequest) (*pb.TaskResponse, error) {
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "update_mask must name at least one field")
+	}
+	for _, path := range paths {
+		switch path {
+		case updateDescription, updateQueue:
+		case updateLabels:
+			if err := validateLabels(req.Labels); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+		case updatePriority:
+			if !validPriority(req.Priority) {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid priority %q", req.Priority)
+			}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "field %q cannot be updated", path)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, exists := s.tasks[req.TaskId]
+	if !exists || !canChange(ctx, task) {
+		return nil, status.Errorf(codes.NotFound, "task %s not found", req.TaskId)
+	}
+	if task.status != statusQueued {
+		return nil, status.Errorf(codes.FailedPrecondition, "task %s is %s, only QUEUED tasks can be updated", req.TaskId, task.status)
+	}
+	if slices.Contains(paths, updatePriority) {
+		if err := s.tenants[task.tenant].checkPriority(req.Priority); err != nil {
+			return nil, err
+		}
+	}
+	var q *queue
+	if slices.Contains(paths, updateQueue) {
+		var err error
+		if q, err = s.tenants[task.tenant].queueByName(req.Queue); err != nil {
+			return nil, err
+		}
+		if q.draining && q.name != task.queue {
+			return nil, status.Errorf(codes.FailedPrecondition, "queue %q is draining", q.name)
+		}
+	}
+
+	for _, path := range paths {
+		switch path {
+		case updateDescription:
+			task.description = req.TaskDescription
+			task.record("description changed to %q", req.TaskDescription)
+		case updatePriority:
+			task.record("priority changed from %s to %s", task.priority, req.Priority)
+			s.setPriority(task, req.Priority)
+		case updateLabels:
+			s.unindexLabels(task)
+			task.labels = maps.Clone(req.Labels)
+			s.indexLabels(task)
+			task.record("labels changed to %v", req.Labels)
+		}
+	}
+	// Move the task last, as the new queue may start it right away.
+	if q != nil && q.name != task.queue {
+		task.record("moved from queue %s to %s", task.queue, q.name)
+		s.setQueue(task, q)
+	}
+
+	return &pb.TaskResponse{TaskId: task.id}, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	pb "github.com/maciekb2/task-manager/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// mask returns an update mask naming the fields.
+func mask(paths ...string) *fieldmaskpb.FieldMask {
+	return &fieldmaskpb.FieldMask{Paths: paths}
+}
+
+// newQueuedServer returns a server whose default queue and a "batch" queue
+// are paused, so submitted tasks stay QUEUED.
+func newQueuedServer(t *testing.T) *server {
+	t.Helper()
+	s := newServer()
+	if _, err := s.CreateQueue(context.Background(), &pb.CreateQueueRequest{Queue: &pb.Queue{Name: "batch"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range s.tenants[defaultTenant].queues {
+		q.paused = true
+	}
+	return s
+}
+
+func TestUpdateTaskRejections(t *testing.T) {
+	s := newQueuedServer(t)
+	s.tenants["acme"] = newTenant("acme", tenantConfig{})
+	s.tenants[defaultTenant].queues["batch"].draining = true
+	res, err := s.SubmitTask(as("alice", defaultTenant, roleSubmitter), &pb.TaskRequest{TaskDescription: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := as("alice", defaultTenant, roleSubmitter)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *pb.UpdateTaskRequest
+		want codes.Code
+	}{
+		{"no fields", alice, &pb.UpdateTaskRequest{}, codes.InvalidArgument},
+		{"unknown field", alice, &pb.UpdateTaskRequest{UpdateMask: mask("status")}, codes.InvalidArgument},
+		{"invalid priority", alice, &pb.UpdateTaskRequest{Priority: "URGENT", UpdateMask: mask(updatePriority)}, codes.InvalidArgument},
+		{"invalid labels", alice, &pb.UpdateTaskRequest{Labels: map[string]string{"Bad Key": "x"}, UpdateMask: mask(updateLabels)}, codes.InvalidArgument},
+		{"unknown task", alice, &pb.UpdateTaskRequest{TaskId: "42", UpdateMask: mask(updateDescription)}, codes.NotFound},
+		{"task of another owner", as("bob", defaultTenant, roleSubmitter), &pb.UpdateTaskRequest{UpdateMask: mask(updateDescription)}, codes.NotFound},
+		{"task of another tenant", as("root", "acme", roleAdmin), &pb.UpdateTaskRequest{UpdateMask: mask(updateDescription)}, codes.NotFound},
+		{"unknown queue", alice, &pb.UpdateTaskRequest{Queue: "nightly", UpdateMask: mask(updateQueue)}, codes.NotFound},
+		{"draining queue", alice, &pb.UpdateTaskRequest{Queue: "batch", UpdateMask: mask(updateQueue)}, codes.FailedPrecondition},
+		{"admin of the tenant", as("root", defaultTenant, roleAdmin), &pb.UpdateTaskRequest{TaskDescription: "changed", UpdateMask: mask(updateDescription)}, codes.OK},
+		{"owner", alice, &pb.UpdateTaskRequest{TaskDescription: "changed again", UpdateMask: mask(updateDescription)}, codes.OK},
+	}
+	for _, tt := range tests {
+		if tt.req.TaskId == "" {
+			tt.req.TaskId = res.TaskId
+		}
+		if _, err := s.UpdateTask(tt.ctx, tt.req); status.Code(err) != tt.want {
+			t.Errorf("%s: UpdateTask returned %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if got := s.tasks[res.TaskId].description; got != "changed again" {
+		t.Errorf("description is %q, want %q", got, "changed again")
+	}
+}
+
+func TestUpdateTaskOnlyQueued(t *testing.T) {
+	s := newQueuedServer(t)
+	for _, st := range []string{statusInProgress, statusCompleted, statusFailed, statusCancelled} {
+		task := submit(t, s, "", "MEDIUM")
+		s.mu.Lock()
+		s.dequeue(task)
+		s.setStatus(task, st)
+		s.mu.Unlock()
+
+		_, err := s.UpdateTask(context.Background(), &pb.UpdateTaskRequest{TaskId: task.id, Priority: "HIGH", UpdateMask: mask(updatePriority)})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("updating a %s task returned %v, want %v", st, err, codes.FailedPrecondition)
+		}
+		if task.priority != "MEDIUM" {
+			t.Errorf("%s task changed to %s", st, task.priority)
+		}
+	}
+}
+
+func TestUpdateTaskPriorityReordersQueue(t *testing.T) {
+	s := newQueuedServer(t)
+	q := s.tenants[defaultTenant].queues[defaultQueue]
+	a, b, c := submit(t, s, "", "LOW"), submit(t, s, "", "LOW"), submit(t, s, "", "LOW")
+
+	if _, err := s.UpdateTask(context.Background(), &pb.UpdateTaskRequest{TaskId: c.id, Priority: "HIGH", UpdateMask: mask(updatePriority)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pendingOrder(s, q), []string{c.id, a.id, b.id}; !slices.Equal(got, want) {
+		t.Errorf("queue order %v, want %v", got, want)
+	}
+	if _, err := s.UpdateTask(context.Background(), &pb.UpdateTaskRequest{TaskId: c.id, Priority: "LOW", UpdateMask: mask(updatePriority)}); err != nil {
+		t.Fatal(err)
+	}
+	// Lowered again, the task is back behind the tasks queued before it.
+	if got, want := pendingOrder(s, q), []string{a.id, b.id, c.id}; !slices.Equal(got, want) {
+		t.Errorf("queue order %v, want %v", got, want)
+	}
+}
+
+func TestUpdateTaskMovesQueue(t *testing.T) {
+	s := newQueuedServer(t)
+	tn := s.tenants[defaultTenant]
+	from, to := tn.queues[defaultQueue], tn.queues["batch"]
+	early := submit(t, s, "batch", "MEDIUM")
+	task := submit(t, s, "", "MEDIUM")
+	late := submit(t, s, "batch", "MEDIUM")
+
+	if _, err := s.UpdateTask(context.Background(), &pb.UpdateTaskRequest{TaskId: task.id, Queue: "batch", UpdateMask: mask(updateQueue)}); err != nil {
+		t.Fatal(err)
+	}
+	if task.queue != "batch" {
+		t.Errorf("task is in queue %s, want batch", task.queue)
+	}
+	if got := pendingOrder(s, from); len(got) != 0 {
+		t.Errorf("old queue still holds %v", got)
+	}
+	// The task keeps its place by the time it was queued.
+	if got, want := pendingOrder(s, to), []string{early.id, task.id, late.id}; !slices.Equal(got, want) {
+		t.Errorf("new queue order %v, want %v", got, want)
+	}
+	stats, err := s.GetStatistics(context.Background(), &pb.StatisticsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stats.ByQueue[defaultQueue].GetQueued(); n != 0 {
+		t.Errorf("statistics count %d tasks queued in %s, want 0", n, defaultQueue)
+	}
+	if n := stats.ByQueue["batch"].GetQueued(); n != 3 {
+		t.Errorf("statistics count %d tasks queued in batch, want 3", n)
+	}
+
+	// A queue with room starts the task right away.
+	release := make(chan struct{})
+	defer close(release)
+	s.work = blockingWork(release)
+	from.paused = false
+	if _, err := s.UpdateTask(context.Background(), &pb.UpdateTaskRequest{TaskId: task.id, Queue: defaultQueue, UpdateMask: mask(updateQueue)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := taskStatus(s, task); got != statusInProgress {
+		t.Errorf("task moved to a running queue is %s, want %s", got, statusInProgress)
+	}
+}
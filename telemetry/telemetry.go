@@ -0,0 +1,212 @@
+// Package telemetry sets up OpenTelemetry tracing and metrics for the task
+// manager binaries. It is configured through the standard OTEL_* environment
+// variables:
+//
+//   - OTEL_SDK_DISABLED=true turns telemetry off.
+//   - OTEL_TRACES_EXPORTER and OTEL_METRICS_EXPORTER select "otlp",
+//     "console" or "none". Traces default to "otlp", metrics to "none".
+//     For metrics "prometheus" is accepted as well and, like "none", only
+//     uses the readers passed to Setup.
+//   - OTEL_EXPORTER_OTLP_PROTOCOL, or OTEL_EXPORTER_OTLP_TRACES_PROTOCOL and
+//     OTEL_EXPORTER_OTLP_METRICS_PROTOCOL, select "grpc" or "http/protobuf"
+//     (the default). Endpoints, headers, timeouts and certificates are read
+//     by the OTLP exporters from OTEL_EXPORTER_OTLP_*.
+//   - OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG select the sampler,
+//     "parentbased_always_on" by default.
+//   - OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES describe the process.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	metricsdk "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// Exporters and protocols that can be selected through the environment.
+const (
+	exporterOTLP    = "otlp"
+	exporterConsole = "console"
+	exporterNone    = "none"
+	// exporterPrometheus leaves metrics to the readers passed to Setup.
+	exporterPrometheus = "prometheus"
+
+	protocolGRPC = "grpc"
+	protocolHTTP = "http/protobuf"
+)
+
+// Providers are the tracer and meter providers installed by Setup.
+type Providers struct {
+	TracerProvider *tracesdk.TracerProvider
+	MeterProvider  *metricsdk.MeterProvider
+}
+
+// Shutdown flushes pending telemetry and stops the providers.
+func (p *Providers) Shutdown(ctx context.Context) error {
+	return errors.Join(p.TracerProvider.Shutdown(ctx), p.MeterProvider.Shutdown(ctx))
+}
+
+// Setup creates the tracer and meter providers described by the environment
+// and installs them, together with the W3C trace context and baggage
+// propagators, as the global ones. serviceName is used unless
+// OTEL_SERVICE_NAME is set. The readers are added to the meter provider next
+// to the configured exporter, e.g. to serve Prometheus metrics.
+func Setup(ctx context.Context, serviceName string, readers ...metricsdk.Reader) (*Providers, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry resource: %w", err)
+	}
+	sampler, err := samplerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	traceOpts := []tracesdk.TracerProviderOption{
+		tracesdk.WithResource(res),
+		tracesdk.WithSampler(sampler),
+	}
+	metricOpts := []metricsdk.Option{metricsdk.WithResource(res)}
+	for _, r := range readers {
+		metricOpts = append(metricOpts, metricsdk.WithReader(r))
+	}
+
+	if !disabled() {
+		spans, err := spanExporter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if spans != nil {
+			// Always be sure to batch in production.
+			traceOpts = append(traceOpts, tracesdk.WithBatcher(spans))
+		}
+		metrics, err := metricExporter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if metrics != nil {
+			metricOpts = append(metricOpts, metricsdk.WithReader(metricsdk.NewPeriodicReader(metrics)))
+		}
+	}
+
+	p := &Providers{
+		TracerProvider: tracesdk.NewTracerProvider(traceOpts...),
+		MeterProvider:  metricsdk.NewMeterProvider(metricOpts...),
+	}
+	otel.SetTracerProvider(p.TracerProvider)
+	otel.SetMeterProvider(p.MeterProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return p, nil
+}
+
+// disabled reports whether OTEL_SDK_DISABLED turns telemetry off.
+func disabled() bool {
+	off, _ := strconv.ParseBool(os.Getenv("OTEL_SDK_DISABLED"))
+	return off
+}
+
+// env returns the first of the environment variables that is set, or def.
+func env(def string, keys ...string) string {
+	for _, key := range keys {
+		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			return strings.ToLower(v)
+		}
+	}
+	return def
+}
+
+// spanExporter returns the exporter selected by OTEL_TRACES_EXPORTER, or nil
+// when traces are not exported.
+func spanExporter(ctx context.Context) (tracesdk.SpanExporter, error) {
+	switch exporter := env(exporterOTLP, "OTEL_TRACES_EXPORTER"); exporter {
+	case exporterNone:
+		return nil, nil
+	case exporterConsole:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case exporterOTLP:
+		switch protocol := env(protocolHTTP, "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"); protocol {
+		case protocolGRPC:
+			return otlptracegrpc.New(ctx)
+		case protocolHTTP:
+			return otlptracehttp.New(ctx)
+		default:
+			return nil, fmt.Errorf("unsupported OTLP traces protocol %q", protocol)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported traces exporter %q", exporter)
+	}
+}
+
+// metricExporter returns the exporter selected by OTEL_METRICS_EXPORTER, or
+// nil when metrics are not pushed.
+func metricExporter(ctx context.Context) (metricsdk.Exporter, error) {
+	switch exporter := env(exporterNone, "OTEL_METRICS_EXPORTER"); exporter {
+	case exporterNone, exporterPrometheus:
+		return nil, nil
+	case exporterConsole:
+		return stdoutmetric.New(stdoutmetric.WithPrettyPrint())
+	case exporterOTLP:
+		switch protocol := env(protocolHTTP, "OTEL_EXPORTER_OTLP_METRICS_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"); protocol {
+		case protocolGRPC:
+			return otlpmetricgrpc.New(ctx)
+		case protocolHTTP:
+			return otlpmetrichttp.New(ctx)
+		default:
+			return nil, fmt.Errorf("unsupported OTLP metrics protocol %q", protocol)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported metrics exporter %q", exporter)
+	}
+}
+
+// samplerFromEnv returns the sampler selected by OTEL_TRACES_SAMPLER. The
+// ratio samplers take their ratio from OTEL_TRACES_SAMPLER_ARG, 1 when unset.
+func samplerFromEnv() (tracesdk.Sampler, error) {
+	name := env("parentbased_always_on", "OTEL_TRACES_SAMPLER")
+	ratio := 1.0
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" && strings.HasSuffix(name, "traceidratio") {
+		r, err := strconv.ParseFloat(arg, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("invalid sampler ratio %q, must be between 0 and 1", arg)
+		}
+		ratio = r
+	}
+
+	switch name {
+	case "always_on":
+		return tracesdk.AlwaysSample(), nil
+	case "always_off":
+		return tracesdk.NeverSample(), nil
+	case "traceidratio":
+		return tracesdk.TraceIDRatioBased(ratio), nil
+	case "parentbased_always_on":
+		return tracesdk.ParentBased(tracesdk.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return tracesdk.ParentBased(tracesdk.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return tracesdk.ParentBased(tracesdk.TraceIDRatioBased(ratio)), nil
+	default:
+		return nil, fmt.Errorf("unsupported traces sampler %q", name)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/maciekb2/task-manager/proto"
+)
+
+// Size of the charts in pixels.
+const (
+	chartWidth  = 600
+	chartHeight = 150
+)
+
+// chartRanges are the time ranges the dashboard charts can show, with the
+// resolution of each.
+var chartRanges = []struct {
+	name       string
+	span       time.Duration
+	resolution time.Duration
+}{
+	{"1h", time.Hour, time.Minute},
+	{"6h", 6 * time.Hour, 5 * time.Minute},
+	{"24h", 24 * time.Hour, 15 * time.Minute},
+}
+
+// chart is a line chart rendered as SVG by the dashboard template.
+type chart struct {
+	Title  string
+	Width  int
+	Height int
+	// Max is the value at the top of the chart, and Start and End label
+	// the first and last point.
+	Max        string
+	Start, End string
+	Series     []series
+}
+
+// series is one line of a chart. Points that have no value split the line
+// into several segments, each a list of "x,y" coordinates.
+type series struct {
+	Name     string
+	Color    string
+	Segments []string
+}
+
+// seriesColors are the colors of the series of a chart, in order.
+var seriesColors = []string{"#1f77b4", "#2ca02c", "#d62728", "#ff7f0e"}
+
+// newChart returns a chart with a series for every name, taking the value of
+// a series at every point from value. Points for which value reports false
+// are left out of the series. format labels the top of the chart.
+func newChart(title string, points []*pb.StatisticsPoint, names []string, value func(p *pb.StatisticsPoint, series int) (float64, bool), format func(float64) string) chart {
+	c := chart{Title: title, Width: chartWidth, Height: chartHeight}
+	if len(points) == 0 {
+		return c
+	}
+	c.Start = points[0].GetTime().AsTime().Local().Format("15:04")
+	c.End = points[len(points)-1].GetTime().AsTime().Local().Format("15:04")
+
+	top := 0.0
+	for i := range names {
+		for _, p := range points {
+			if v, ok := value(p, i); ok && v > top {
+				top = v
+			}
+		}
+	}
+	if top == 0 {
+		top = 1
+	}
+	c.Max = format(top)
+
+	step := float64(chartWidth)
+	if len(points) > 1 {
+		step = float64(chartWidth) / float64(len(points)-1)
+	}
+	for i, name := range names {
+		s := series{Name: name, Color: seriesColors[i%len(seriesColors)]}
+		var segment []string
+		for j, p := range points {
+			v, ok := value(p, i)
+			if !ok {
+				if len(segment) > 0 {
+					s.Segments = append(s.Segments, strings.Join(segment, " "))
+					segment = nil
+				}
+				continue
+			}
+			x := float64(j) * step
+			y := float64(chartHeight) * (1 - v/top)
+			segment = append(segment, fmt.Sprintf("%.1f,%.1f", x, y))
+		}
+		if len(segment) > 0 {
+			s.Segments = append(s.Segments, strings.Join(segment, " "))
+		}
+		c.Series = append(c.Series, s)
+	}
+	return c
+}
+
+// historyCharts returns the trend charts of the statistics history: the tasks
+// submitted and finished per interval, and the average run time per
+// priority.
+func historyCharts(history *pb.StatisticsHistoryResponse) []chart {
+	points := history.GetPoints()
+	per := history.GetResolution().AsDuration()
+
+	counts := newChart(fmt.Sprintf("Tasks per %s", strings.TrimSuffix(per.String(), "0s")), points,
+		[]string{"Submitted", "Completed", "Failed", "Cancelled"},
+		func(p *pb.StatisticsPoint, series int) (float64, bool) {
+			total := int32(0)
+			for _, a := range p.GetByPriority() {
+				total += []int32{a.GetSubmitted(), a.GetCompleted(), a.GetFailed(), a.GetCancelled()}[series]
+			}
+			return float64(total), true
+		},
+		func(v float64) string { return fmt.Sprintf("%.0f", v) },
+	)
+
+	priorities := []string{"HIGH", "MEDIUM", "LOW"}
+	durations := newChart("Average run time", points, priorities,
+		func(p *pb.StatisticsPoint, series int) (float64, bool) {
+			a := p.GetByPriority()[priorities[series]]
+			if a.GetAverageDuration() == nil {
+				return 0, false
+			}
+			return a.GetAverageDuration().AsDuration().Seconds(), true
+		},
+		func(v float64) string {
+			return time.Duration(v * float64(time.Second)).Round(time.Millisecond).String()
+		},
+	)
+	return []chart{counts, durations}
+}
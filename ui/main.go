@@ -2,58 +2,253 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/maciekb2/task-manager/logging"
 	pb "github.com/maciekb2/task-manager/proto"
+	"github.com/maciekb2/task-manager/telemetry"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
 	grpcAddr = "taskmanager-service:50051"
 	client   pb.TaskManagerClient
+
+	useTLS        = flag.Bool("tls", false, "connect to the server over TLS")
+	tlsCAFile     = flag.String("tls-ca-file", "", "CA bundle for verifying the server certificate, the system roots when empty")
+	tlsCertFile   = flag.String("tls-cert-file", "", "client certificate file for mutual TLS")
+	tlsKeyFile    = flag.String("tls-key-file", "", "client private key file for mutual TLS")
+	tlsServerName = flag.String("tls-server-name", "", "server name to verify, the host of the server address when empty")
+	apiKey        = flag.String("api-key", os.Getenv("TASKMANAGER_API_KEY"), "API key sent to the server (env TASKMANAGER_API_KEY)")
+	bearerToken   = flag.String("token", os.Getenv("TASKMANAGER_TOKEN"), "JWT bearer token sent to the server (env TASKMANAGER_TOKEN)")
+	logFormat     = flag.String("log-format", envOr("TASKMANAGER_LOG_FORMAT", "text"), `log format, "text" or "json" (env TASKMANAGER_LOG_FORMAT)`)
+	logLevel      = flag.String("log-level", envOr("TASKMANAGER_LOG_LEVEL", "info"), `lowest level logged: "debug", "info", "warn" or "error" (env TASKMANAGER_LOG_LEVEL)`)
 )
 
+// transportCredentials returns the credentials used to connect to the server:
+// plaintext unless TLS is enabled, with a client certificate for mutual TLS
+// when one is given. The certificate files are loaded again when they change.
+func transportCredentials() (credentials.TransportCredentials, error) {
+	if !*useTLS {
+		return insecure.NewCredentials(), nil
+	}
+	var files []string
+	for _, name := range []string{*tlsCAFile, *tlsCertFile, *tlsKeyFile} {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return newReloadingCredentials(files, tlsCredentials)
+}
+
+// tlsCredentials loads the CA bundle and the client certificate from their
+// files.
+func tlsCredentials() (credentials.TransportCredentials, error) {
+	config := &tls.Config{
+		ServerName: *tlsServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if *tlsCAFile != "" {
+		pem, err := os.ReadFile(*tlsCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", *tlsCAFile)
+		}
+		config.RootCAs = pool
+	}
+	if *tlsCertFile != "" || *tlsKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// tokenCredentials sends the API key or bearer token with every RPC. They are
+// also sent over plaintext connections, so the server can be run without TLS
+// inside a trusted network.
+type tokenCredentials struct {
+	apiKey, token string
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if c.apiKey != "" {
+		return map[string]string{"x-api-key": c.apiKey}, nil
+	}
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// dialOptions returns the options for connecting to the server with the
+// configured transport and call credentials.
+func dialOptions() ([]grpc.DialOption, error) {
+	creds, err := transportCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS credentials: %w", err)
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	}
+	if *apiKey != "" || *bearerToken != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{apiKey: *apiKey, token: *bearerToken}))
+	}
+	return opts, nil
+}
+
+// envOr returns the environment variable, or def when it is not set.
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// setupLogging makes a logger with the configured format and level the
+// default one. Lines logged while serving a request carry its trace and span
+// IDs.
+func setupLogging() error {
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", *logLevel)
+	}
+	var v slog.LevelVar
+	v.Set(level)
+	logger, err := logging.New(os.Stderr, *logFormat, &v)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// traced starts a span for every request served by the handler, so the calls
+// made for it and the lines logged share the trace of the request.
+func traced(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := otel.Tracer("taskmanager-ui").Start(r.Context(), name)
+		defer span.End()
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
 func main() {
+	flag.StringVar(&grpcAddr, "server", grpcAddr, "address of the task manager server")
+	flag.Parse()
+	if err := setupLogging(); err != nil {
+		fatal("failed to set up logging", err)
+	}
+
+	// Initialize OpenTelemetry from the OTEL_* environment.
+	providers, err := telemetry.Setup(context.Background(), "taskmanager-ui")
+	if err != nil {
+		fatal("failed to set up telemetry", err)
+	}
+	defer providers.Shutdown(context.Background())
+
+	opts, err := dialOptions()
+	if err != nil {
+		fatal("invalid connection options", err)
+	}
+
 	// Set up a connection to the server.
-	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure(), grpc.WithBlock())
+	conn, err := grpc.Dial(grpcAddr, append(opts, grpc.WithBlock())...)
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		fatal("did not connect", err)
 	}
 	defer conn.Close()
 	client = pb.NewTaskManagerClient(conn)
 
-	http.HandleFunc("/", dashboardHandler)
-	http.HandleFunc("/submit", submitHandler)
+	http.HandleFunc("/", traced("dashboard", dashboardHandler))
+	http.HandleFunc("/submit", traced("submit", submitHandler))
 
-	log.Println("UI server listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	slog.Info("UI server listening", "addr", ":8080")
+	fatal("failed to serve", http.ListenAndServe(":8080", nil))
+}
+
+// dashboard is the data of the dashboard template.
+type dashboard struct {
+	*pb.StatisticsResponse
+	// Charts show the statistics history over Range, one of Ranges.
+	Charts []chart
+	Range  string
+	Ranges []string
 }
 
 func dashboardHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
 	defer cancel()
 
 	stats, err := client.GetStatistics(ctx, &pb.StatisticsRequest{})
 	if err != nil {
 		http.Error(w, "Error fetching statistics", http.StatusInternalServerError)
-		log.Printf("could not get statistics: %v", err)
+		slog.ErrorContext(ctx, "could not get statistics", "error", err)
 		return
 	}
 
+	// The charts cover the range picked on the page, the last hour by
+	// default. Without a history the page is shown without charts.
+	data := dashboard{StatisticsResponse: stats, Range: chartRanges[0].name}
+	for _, rng := range chartRanges {
+		data.Ranges = append(data.Ranges, rng.name)
+		if rng.name == r.FormValue("range") {
+			data.Range = rng.name
+		}
+	}
+	for _, rng := range chartRanges {
+		if rng.name != data.Range {
+			continue
+		}
+		history, err := client.GetStatisticsHistory(ctx, &pb.StatisticsHistoryRequest{
+			StartTime:  timestamppb.New(time.Now().Add(-rng.span)),
+			Resolution: durationpb.New(rng.resolution),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "could not get statistics history", "error", err)
+			break
+		}
+		data.Charts = historyCharts(history)
+	}
+
 	tmpl, err := template.ParseFiles("templates/dashboard.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
-		log.Printf("could not parse template: %v", err)
+		slog.ErrorContext(ctx, "could not parse template", "error", err)
 		return
 	}
 
-	err = tmpl.Execute(w, stats)
+	err = tmpl.Execute(w, data)
 	if err != nil {
 		http.Error(w, "Error rendering template", http.StatusInternalServerError)
-		log.Printf("could not execute template: %v", err)
+		slog.ErrorContext(ctx, "could not execute template", "error", err)
 	}
 }
 
@@ -71,18 +266,19 @@ func submitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
 	defer cancel()
 
-	_, err := client.SubmitTask(ctx, &pb.TaskRequest{
+	res, err := client.SubmitTask(ctx, &pb.TaskRequest{
 		TaskDescription: description,
 		Priority:        priority,
 	})
 	if err != nil {
 		http.Error(w, "Error submitting task", http.StatusInternalServerError)
-		log.Printf("could not submit task: %v", err)
+		slog.ErrorContext(ctx, "could not submit task", "error", err)
 		return
 	}
+	slog.InfoContext(ctx, "task submitted", "task_id", res.TaskId, "priority", priority)
 
 	http.Redirect(w, r, "/", http.StatusFound)
-}
\ No newline at end of file
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes. Checks only happen while connections are made.
+const certCheckInterval = 10 * time.Second
+
+// reloadingCredentials are TLS credentials built from certificate files. The
+// files are loaded again when they change, so new connections to the server
+// pick up rotated certificates without a restart.
+type reloadingCredentials struct {
+	files []string
+	load  func() (credentials.TransportCredentials, error)
+
+	mu       sync.Mutex
+	creds    credentials.TransportCredentials
+	modTimes []time.Time
+	checked  time.Time
+}
+
+// newReloadingCredentials loads the credentials with load, which reads the
+// files.
+func newReloadingCredentials(files []string, load func() (credentials.TransportCredentials, error)) (*reloadingCredentials, error) {
+	c := &reloadingCredentials{files: files, load: load}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the files and builds new credentials from them.
+func (c *reloadingCredentials) reload() error {
+	modTimes, err := c.statFiles()
+	if err != nil {
+		return err
+	}
+	creds, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creds = creds
+	c.modTimes = modTimes
+	c.checked = time.Now()
+	return nil
+}
+
+// statFiles returns the modification times of the files.
+func (c *reloadingCredentials) statFiles() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, name := range c.files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+// current returns the credentials, reloading them first when the files have
+// changed since they were last checked. A failed reload keeps the previous
+// credentials so a half-written rotation does not break new connections.
+func (c *reloadingCredentials) current() credentials.TransportCredentials {
+	c.mu.Lock()
+	creds, checked, modTimes := c.creds, c.checked, c.modTimes
+	if time.Since(checked) < certCheckInterval {
+		c.mu.Unlock()
+		return creds
+	}
+	c.checked = time.Now()
+	c.mu.Unlock()
+
+	latest, err := c.statFiles()
+	if err != nil || slices.EqualFunc(latest, modTimes, time.Time.Equal) {
+		return creds
+	}
+	if err := c.reload(); err != nil {
+		slog.Error("keeping previous TLS certificates", "error", err)
+		return creds
+	}
+	slog.Info("TLS certificates reloaded")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.creds
+}
+
+func (c *reloadingCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.current().ClientHandshake(ctx, authority, conn)
+}
+
+func (c *reloadingCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.current().ServerHandshake(conn)
+}
+
+func (c *reloadingCredentials) Info() credentials.ProtocolInfo {
+	return c.current().Info()
+}
+
+// Clone returns credentials that share the files and the loaded certificates.
+func (c *reloadingCredentials) Clone() credentials.TransportCredentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &reloadingCredentials{
+		files:    c.files,
+		load:     c.load,
+		creds:    c.creds.Clone(),
+		modTimes: c.modTimes,
+		checked:  c.checked,
+	}
+}
+
+// OverrideServerName is not supported, the server name is set with
+// -tls-server-name.
+func (c *reloadingCredentials) OverrideServerName(string) error {
+	return errors.New("overriding the server name is not supported")
+}